@@ -1,18 +1,274 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"time"
 )
 
-// 定义上传文件存储目录
+// Config 中继服务配置
+// 加载顺序：默认值 < 配置文件 < 环境变量 < 命令行参数
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Upload   UploadConfig   `yaml:"upload"`
+	Download DownloadConfig `yaml:"download"`
+	Janitor  JanitorConfig  `yaml:"janitor"`
+	Quota    QuotaConfig    `yaml:"quota"`
+	Socket   SocketConfig   `yaml:"socket"`
+	Outbox   OutboxConfig   `yaml:"outbox"`
+	CORS     CORSConfig     `yaml:"cors"`
+}
+
+// ServerConfig HTTP服务配置
+type ServerConfig struct {
+	Addr               string        `yaml:"addr"`                 // 监听地址
+	MaxMultipartMemory int64         `yaml:"max_multipart_memory"` // multipart 表单驻留内存的上限，超出部分写入临时文件
+	ReadTimeout        time.Duration `yaml:"read_timeout"`         // 读取整个请求的超时时间，0 表示不限制
+	WriteTimeout       time.Duration `yaml:"write_timeout"`        // 写入响应的超时时间，0 表示不限制
+	IdleTimeout        time.Duration `yaml:"idle_timeout"`         // keep-alive 连接的空闲超时时间
+}
+
+// StorageConfig 存储配置
+type StorageConfig struct {
+	Backend    string   `yaml:"backend"`     // 存储后端：local（默认）或 s3
+	UploadsDir string   `yaml:"uploads_dir"` // local 后端的存储根目录
+	TempDir    string   `yaml:"temp_dir"`    // 分块临时目录，始终位于本地磁盘
+	DBPath     string   `yaml:"db_path"`     // 嵌入式数据库文件路径，用于持久化节点注册表等数据
+	S3         S3Config `yaml:"s3"`          // s3 后端配置
+}
+
+// S3Config 兼容 S3 协议的对象存储配置
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`   // 服务地址，如 localhost:9000
+	Bucket    string `yaml:"bucket"`     // 桶名
+	Region    string `yaml:"region"`     // 区域
+	AccessKey string `yaml:"access_key"` // 访问密钥ID
+	SecretKey string `yaml:"secret_key"` // 访问密钥
+	UseSSL    bool   `yaml:"use_ssl"`    // 是否使用 HTTPS
+	Prefix    string `yaml:"prefix"`     // 对象名前缀，便于多个中继共用一个桶
+}
+
+// UploadConfig 上传配置
+type UploadConfig struct {
+	SimpleMaxSize    int64 `yaml:"simple_max_size"`    // 简单上传允许的最大文件大小
+	DefaultChunkSize int64 `yaml:"default_chunk_size"` // 客户端未指定时使用的分块大小
+	MaxChunkSize     int64 `yaml:"max_chunk_size"`     // 允许的最大分块大小
+
+	TusExpiration time.Duration `yaml:"tus_expiration"` // tus 上传会话无活动后的过期时间
+	TusMaxSize    int64         `yaml:"tus_max_size"`   // tus 上传允许的最大文件大小，通过 Tus-Max-Size 告知客户端，0 表示不限制
+
+	// Assembly 分块上传的组装方式：
+	// chunks（默认）每个分块保存为单独的临时文件，完成时合并；
+	// positional 初始化时预分配整个文件，分块按偏移量直接写入，完成时只需校验和重命名，适合大文件
+	Assembly string `yaml:"assembly"`
+}
+
+// 分块上传的组装方式
+const (
+	AssemblyChunks     = "chunks"
+	AssemblyPositional = "positional"
+)
+
+// DownloadConfig 下载配置
+type DownloadConfig struct {
+	DefaultChunkSize int64 `yaml:"default_chunk_size"` // 客户端未指定时使用的分块大小
+	MaxChunkSize     int64 `yaml:"max_chunk_size"`     // 允许的最大分块大小
+
+	ManifestChunkSizes []int64 `yaml:"manifest_chunk_sizes"` // 上传完成后预先计算分块哈希的分块大小
+}
+
+// JanitorConfig 后台清理配置，各项过期时间为 0 表示不清理该类数据
+type JanitorConfig struct {
+	Interval    time.Duration `yaml:"interval"`     // 清理间隔，0 表示不启动后台清理
+	UploadTTL   time.Duration `yaml:"upload_ttl"`   // 分块上传会话无活动后的过期时间，tus 会话使用 upload.tus_expiration
+	DownloadTTL time.Duration `yaml:"download_ttl"` // 下载会话无活动后的过期时间
+	OrphanTTL   time.Duration `yaml:"orphan_ttl"`   // 不属于任何会话的临时文件和暂存对象的保留时间
+}
+
+// QuotaConfig 存储配额，按文件大小统计，进行中的上传按声明的文件大小预留；各项为 0 表示不限制
+type QuotaConfig struct {
+	NodeBytes    int64            `yaml:"node_bytes"`     // 每个节点（上传者）可占用的存储空间
+	Nodes        map[string]int64 `yaml:"nodes"`          // 单独设置配额的节点，优先于 node_bytes
+	GlobalBytes  int64            `yaml:"global_bytes"`   // 所有节点合计可占用的存储空间
+	MinFreeBytes int64            `yaml:"min_free_bytes"` // 磁盘剩余空间的水位线，写入后低于该值的上传会被拒绝
+}
+
+// NodeLimit 节点的配额，0 表示不限制
+func (q *QuotaConfig) NodeLimit(uid string) int64 {
+	if limit, ok := q.Nodes[uid]; ok {
+		return limit
+	}
+	return q.NodeBytes
+}
+
+// SocketConfig 节点WebSocket连接配置
+type SocketConfig struct {
+	SendQueueSize int           `yaml:"send_queue_size"` // 每个连接待发送消息队列的长度
+	WriteTimeout  time.Duration `yaml:"write_timeout"`   // 写出一条消息的超时时间，超时的连接会被断开
+	QueuePolicy   string        `yaml:"queue_policy"`    // 队列已满时的处理方式：drop_oldest 丢弃最早的消息，disconnect 断开慢速连接
+
+	PingInterval time.Duration `yaml:"ping_interval"` // 服务端向节点发送 ping 的间隔
+	PongTimeout  time.Duration `yaml:"pong_timeout"`  // 发送 ping 后等待 pong 的时间，超过 ping_interval + pong_timeout 没有收到任何数据即断开连接
+
+	RPCTimeout    time.Duration `yaml:"rpc_timeout"`     // RPC 请求未指定超时时间时等待节点响应的时间
+	RPCMaxTimeout time.Duration `yaml:"rpc_max_timeout"` // RPC 请求可指定的最长超时时间
+}
+
+// 待发送队列已满时的处理方式
 const (
-	UploadsDir = "./uploads"
-	TempDir    = "./uploads/temp"
+	QueueDropOldest = "drop_oldest"
+	QueueDisconnect = "disconnect"
 )
 
+// OutboxConfig 发往节点的消息的投递配置
+type OutboxConfig struct {
+	TTL              time.Duration `yaml:"ttl"`                // 消息默认的保留时间，过期后不再投递
+	MaxMessages      int           `yaml:"max_messages"`       // 每个节点最多保留的消息数，超出时丢弃优先级最低、最早写入的消息，0 表示不限制
+	MaxAttempts      int           `yaml:"max_attempts"`       // 每条消息最多发送的次数，仍未确认时标记为失败
+	RetryInterval    time.Duration `yaml:"retry_interval"`     // 首次发送后等待确认的时间，之后每次重发翻倍
+	MaxRetryInterval time.Duration `yaml:"max_retry_interval"` // 重发间隔的上限
+	StatusRetention  time.Duration `yaml:"status_retention"`   // 结束投递的消息保留多久以供查询投递状态
+}
+
+// CORSConfig 跨域配置
+type CORSConfig struct {
+	AllowOrigins []string `yaml:"allow_origins"` // 允许的来源，为空或包含 "*" 时允许所有来源
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:               ":8080",
+			MaxMultipartMemory: 8 << 20, // 8 MiB
+			IdleTimeout:        120 * time.Second,
+		},
+		Storage: StorageConfig{
+			Backend:    "local",
+			UploadsDir: "./uploads",
+			TempDir:    "./uploads/temp",
+			DBPath:     "./data/relay.db",
+		},
+		Upload: UploadConfig{
+			SimpleMaxSize:    10 << 20, // 10 MiB
+			DefaultChunkSize: 1 << 20,  // 1 MiB
+			MaxChunkSize:     64 << 20, // 64 MiB
+			TusExpiration:    24 * time.Hour,
+			Assembly:         AssemblyChunks,
+		},
+		Download: DownloadConfig{
+			DefaultChunkSize:   1 << 20,  // 1 MiB
+			MaxChunkSize:       64 << 20, // 64 MiB
+			ManifestChunkSizes: []int64{1 << 20, 4 << 20, 16 << 20},
+		},
+		Janitor: JanitorConfig{
+			Interval:    10 * time.Minute,
+			UploadTTL:   24 * time.Hour,
+			DownloadTTL: 24 * time.Hour,
+			OrphanTTL:   time.Hour,
+		},
+		Quota: QuotaConfig{
+			MinFreeBytes: 512 << 20, // 512 MiB
+		},
+		Socket: SocketConfig{
+			SendQueueSize: 256,
+			WriteTimeout:  10 * time.Second,
+			QueuePolicy:   QueueDisconnect,
+			PingInterval:  30 * time.Second,
+			PongTimeout:   10 * time.Second,
+			RPCTimeout:    10 * time.Second,
+			RPCMaxTimeout: time.Minute,
+		},
+		Outbox: OutboxConfig{
+			TTL:              24 * time.Hour,
+			MaxMessages:      1000,
+			MaxAttempts:      5,
+			RetryInterval:    10 * time.Second,
+			MaxRetryInterval: 5 * time.Minute,
+			StatusRetention:  24 * time.Hour,
+		},
+	}
+}
+
+// Validate 校验配置的合法性
+func (c *Config) Validate() error {
+	if c.Server.Addr == "" {
+		return fmt.Errorf("server.addr 不能为空")
+	}
+	if c.Storage.UploadsDir == "" || c.Storage.TempDir == "" || c.Storage.DBPath == "" {
+		return fmt.Errorf("storage 路径不能为空")
+	}
+	if c.Storage.Backend == "s3" && (c.Storage.S3.Endpoint == "" || c.Storage.S3.Bucket == "") {
+		return fmt.Errorf("s3 存储后端需要配置 endpoint 和 bucket")
+	}
+	if c.Upload.DefaultChunkSize <= 0 || c.Upload.MaxChunkSize < c.Upload.DefaultChunkSize {
+		return fmt.Errorf("upload 分块大小配置不正确")
+	}
+	if c.Upload.TusMaxSize < 0 {
+		return fmt.Errorf("upload.tus_max_size 不能为负数")
+	}
+	if c.Upload.Assembly != AssemblyChunks && c.Upload.Assembly != AssemblyPositional {
+		return fmt.Errorf("upload.assembly 只能为 %s 或 %s", AssemblyChunks, AssemblyPositional)
+	}
+	if c.Download.DefaultChunkSize <= 0 || c.Download.MaxChunkSize < c.Download.DefaultChunkSize {
+		return fmt.Errorf("download 分块大小配置不正确")
+	}
+	for _, size := range c.Download.ManifestChunkSizes {
+		if size <= 0 || size > c.Download.MaxChunkSize {
+			return fmt.Errorf("download.manifest_chunk_sizes 中的分块大小 %d 不正确", size)
+		}
+	}
+	if c.Janitor.Interval < 0 || c.Janitor.UploadTTL < 0 || c.Janitor.DownloadTTL < 0 || c.Janitor.OrphanTTL < 0 {
+		return fmt.Errorf("janitor 的时间配置不能为负数")
+	}
+	if c.Quota.NodeBytes < 0 || c.Quota.GlobalBytes < 0 || c.Quota.MinFreeBytes < 0 {
+		return fmt.Errorf("quota 配置不能为负数")
+	}
+	for uid, limit := range c.Quota.Nodes {
+		if limit < 0 {
+			return fmt.Errorf("quota.nodes 中节点 %s 的配额不能为负数", uid)
+		}
+	}
+	if c.Socket.SendQueueSize <= 0 || c.Socket.WriteTimeout <= 0 {
+		return fmt.Errorf("socket 的队列长度和写超时时间必须大于 0")
+	}
+	if c.Socket.PingInterval <= 0 || c.Socket.PongTimeout <= 0 {
+		return fmt.Errorf("socket 的心跳间隔和超时时间必须大于 0")
+	}
+	if c.Socket.RPCTimeout <= 0 || c.Socket.RPCMaxTimeout < c.Socket.RPCTimeout {
+		return fmt.Errorf("socket.rpc_timeout 必须大于 0，socket.rpc_max_timeout 不能小于 socket.rpc_timeout")
+	}
+	if c.Outbox.TTL <= 0 || c.Outbox.MaxMessages < 0 {
+		return fmt.Errorf("outbox.ttl 必须大于 0，outbox.max_messages 不能为负数")
+	}
+	if c.Outbox.MaxAttempts <= 0 || c.Outbox.RetryInterval <= 0 || c.Outbox.MaxRetryInterval < c.Outbox.RetryInterval || c.Outbox.StatusRetention <= 0 {
+		return fmt.Errorf("outbox.max_attempts、outbox.retry_interval 和 outbox.status_retention 必须大于 0，outbox.max_retry_interval 不能小于 outbox.retry_interval")
+	}
+	if c.Socket.QueuePolicy != QueueDropOldest && c.Socket.QueuePolicy != QueueDisconnect {
+		return fmt.Errorf("socket.queue_policy 只能为 %s 或 %s", QueueDropOldest, QueueDisconnect)
+	}
+	return nil
+}
+
 // Init 初始化配置
-func Init() {
+func (c *Config) Init() error {
 	// 确保上传目录存在
-	os.MkdirAll(UploadsDir, 0755)
-	os.MkdirAll(TempDir, 0755)
+	if err := os.MkdirAll(c.Storage.UploadsDir, 0755); err != nil {
+		return err
+	}
+	return os.MkdirAll(c.Storage.TempDir, 0755)
+}
+
+// AllowAllOrigins 是否允许所有来源跨域
+func (c *CORSConfig) AllowAllOrigins() bool {
+	if len(c.AllowOrigins) == 0 {
+		return true
+	}
+	for _, origin := range c.AllowOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
 }
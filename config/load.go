@@ -0,0 +1,222 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix 环境变量前缀
+const envPrefix = "RELAY_"
+
+// option 描述一个可通过环境变量和命令行参数覆盖的配置项
+type option struct {
+	name  string             // 命令行参数名，对应的环境变量为 RELAY_ + 大写并将 - 和 . 替换为 _
+	usage string             // 参数说明
+	set   func(string) error // 将字符串值写入配置
+}
+
+// options 列出所有可覆盖的配置项
+func (c *Config) options() []option {
+	return []option{
+		{"server.addr", "监听地址", stringSetter(&c.Server.Addr)},
+		{"server.max-multipart-memory", "multipart 表单驻留内存上限（字节）", int64Setter(&c.Server.MaxMultipartMemory)},
+		{"server.read-timeout", "读取请求超时时间，如 30s", durationSetter(&c.Server.ReadTimeout)},
+		{"server.write-timeout", "写入响应超时时间，如 30s", durationSetter(&c.Server.WriteTimeout)},
+		{"server.idle-timeout", "空闲连接超时时间，如 2m", durationSetter(&c.Server.IdleTimeout)},
+		{"storage.backend", "存储后端：local 或 s3", stringSetter(&c.Storage.Backend)},
+		{"storage.uploads-dir", "local 后端的存储根目录", stringSetter(&c.Storage.UploadsDir)},
+		{"storage.temp-dir", "分块临时目录", stringSetter(&c.Storage.TempDir)},
+		{"storage.db-path", "嵌入式数据库文件路径", stringSetter(&c.Storage.DBPath)},
+		{"storage.s3.endpoint", "S3 服务地址", stringSetter(&c.Storage.S3.Endpoint)},
+		{"storage.s3.bucket", "S3 桶名", stringSetter(&c.Storage.S3.Bucket)},
+		{"storage.s3.region", "S3 区域", stringSetter(&c.Storage.S3.Region)},
+		{"storage.s3.access-key", "S3 访问密钥ID", stringSetter(&c.Storage.S3.AccessKey)},
+		{"storage.s3.secret-key", "S3 访问密钥", stringSetter(&c.Storage.S3.SecretKey)},
+		{"storage.s3.use-ssl", "S3 是否使用 HTTPS", boolSetter(&c.Storage.S3.UseSSL)},
+		{"storage.s3.prefix", "S3 对象名前缀", stringSetter(&c.Storage.S3.Prefix)},
+		{"upload.simple-max-size", "简单上传最大文件大小（字节）", int64Setter(&c.Upload.SimpleMaxSize)},
+		{"upload.default-chunk-size", "上传默认分块大小（字节）", int64Setter(&c.Upload.DefaultChunkSize)},
+		{"upload.max-chunk-size", "上传最大分块大小（字节）", int64Setter(&c.Upload.MaxChunkSize)},
+		{"upload.tus-expiration", "tus 上传会话过期时间，如 24h", durationSetter(&c.Upload.TusExpiration)},
+		{"upload.tus-max-size", "tus 上传最大文件大小（字节），0 表示不限制", int64Setter(&c.Upload.TusMaxSize)},
+		{"upload.assembly", "分块上传的组装方式：chunks 或 positional", stringSetter(&c.Upload.Assembly)},
+		{"download.default-chunk-size", "下载默认分块大小（字节）", int64Setter(&c.Download.DefaultChunkSize)},
+		{"download.max-chunk-size", "下载最大分块大小（字节）", int64Setter(&c.Download.MaxChunkSize)},
+		{"download.manifest-chunk-sizes", "预先计算分块哈希的分块大小（字节），逗号分隔", int64SliceSetter(&c.Download.ManifestChunkSizes)},
+		{"janitor.interval", "后台清理间隔，如 10m，0 表示不启用", durationSetter(&c.Janitor.Interval)},
+		{"janitor.upload-ttl", "分块上传会话无活动后的过期时间，如 24h", durationSetter(&c.Janitor.UploadTTL)},
+		{"janitor.download-ttl", "下载会话无活动后的过期时间，如 24h", durationSetter(&c.Janitor.DownloadTTL)},
+		{"janitor.orphan-ttl", "孤立临时文件的保留时间，如 1h", durationSetter(&c.Janitor.OrphanTTL)},
+		{"quota.node-bytes", "每个节点可占用的存储空间（字节），0 表示不限制", int64Setter(&c.Quota.NodeBytes)},
+		{"quota.global-bytes", "所有节点合计可占用的存储空间（字节），0 表示不限制", int64Setter(&c.Quota.GlobalBytes)},
+		{"quota.min-free-bytes", "磁盘剩余空间水位线（字节），0 表示不检查", int64Setter(&c.Quota.MinFreeBytes)},
+		{"socket.send-queue-size", "每个节点连接待发送消息队列的长度", intSetter(&c.Socket.SendQueueSize)},
+		{"socket.write-timeout", "向节点写出一条消息的超时时间，如 10s", durationSetter(&c.Socket.WriteTimeout)},
+		{"socket.queue-policy", "待发送队列已满时的处理方式：drop_oldest 或 disconnect", stringSetter(&c.Socket.QueuePolicy)},
+		{"socket.ping-interval", "向节点发送心跳 ping 的间隔，如 30s", durationSetter(&c.Socket.PingInterval)},
+		{"socket.pong-timeout", "等待节点回复 pong 的超时时间，如 10s", durationSetter(&c.Socket.PongTimeout)},
+		{"socket.rpc-timeout", "RPC 请求默认等待节点响应的时间，如 10s", durationSetter(&c.Socket.RPCTimeout)},
+		{"socket.rpc-max-timeout", "RPC 请求可指定的最长超时时间，如 1m", durationSetter(&c.Socket.RPCMaxTimeout)},
+		{"outbox.ttl", "待投递消息的默认保留时间，如 24h", durationSetter(&c.Outbox.TTL)},
+		{"outbox.max-messages", "每个节点最多保留的待投递消息数，0 表示不限制", intSetter(&c.Outbox.MaxMessages)},
+		{"outbox.max-attempts", "每条消息最多发送的次数", intSetter(&c.Outbox.MaxAttempts)},
+		{"outbox.retry-interval", "首次发送后等待节点确认的时间，之后每次重发翻倍，如 10s", durationSetter(&c.Outbox.RetryInterval)},
+		{"outbox.max-retry-interval", "重发间隔的上限，如 5m", durationSetter(&c.Outbox.MaxRetryInterval)},
+		{"outbox.status-retention", "结束投递的消息保留多久以供查询投递状态，如 24h", durationSetter(&c.Outbox.StatusRetention)},
+		{"cors.allow-origins", "允许跨域的来源，逗号分隔，* 表示全部", stringSliceSetter(&c.CORS.AllowOrigins)},
+	}
+}
+
+// Load 按 默认值 < 配置文件 < 环境变量 < 命令行参数 的顺序加载配置
+// 配置文件路径由 -config 参数或 RELAY_CONFIG 环境变量指定，未指定时只使用默认值
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	opts := cfg.options()
+
+	// 先解析命令行参数，但暂不应用，以保证其优先级最高
+	fs := flag.NewFlagSet("relay", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "配置文件路径（YAML）")
+	flagValues := make(map[string]string)
+	for _, opt := range opts {
+		name := opt.name
+		fs.Func(name, opt.usage, func(v string) error {
+			flagValues[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, opt := range opts {
+		if v, ok := os.LookupEnv(envName(opt.name)); ok {
+			if err := opt.set(v); err != nil {
+				return nil, fmt.Errorf("环境变量 %s 格式不正确: %w", envName(opt.name), err)
+			}
+		}
+	}
+
+	for _, opt := range opts {
+		if v, ok := flagValues[opt.name]; ok {
+			if err := opt.set(v); err != nil {
+				return nil, fmt.Errorf("参数 -%s 格式不正确: %w", opt.name, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile 从YAML文件加载配置，文件中未出现的字段保持原值
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("解析配置文件失败: %w", err)
+	}
+	return nil
+}
+
+// envName 将参数名转换为环境变量名，如 server.addr -> RELAY_SERVER_ADDR
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+func stringSetter(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func int64Setter(p *int64) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*p = n
+		return nil
+	}
+}
+
+func intSetter(p *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*p = n
+		return nil
+	}
+}
+
+func boolSetter(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	}
+}
+
+func durationSetter(p *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*p = d
+		return nil
+	}
+}
+
+func stringSliceSetter(p *[]string) func(string) error {
+	return func(v string) error {
+		var values []string
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		*p = values
+		return nil
+	}
+}
+
+func int64SliceSetter(p *[]int64) func(string) error {
+	return func(v string) error {
+		var values []int64
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			values = append(values, n)
+		}
+		*p = values
+		return nil
+	}
+}
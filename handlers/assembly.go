@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+
+	"com.example/relay/models"
+	"com.example/relay/storage"
+	"com.example/relay/utils"
+)
+
+// 按偏移量写入（upload.assembly=positional）：初始化上传时预分配整个文件，
+// 分块到达后直接写入其偏移量处，完成时只需读取一遍校验，再以硬链接纳入存储
+
+// partFilePath 按偏移量写入的上传会话使用的预分配文件路径
+func partFilePath(info *models.UploadInfo) string {
+	return filepath.Join(relayConfig.Storage.TempDir, models.PartFileName(info.FileID))
+}
+
+// createPartFile 创建并预分配上传会话的目标文件
+func createPartFile(info *models.UploadInfo) error {
+	file, err := os.OpenFile(partFilePath(info), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := utils.PreallocateFile(file, info.TotalSize); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("预分配磁盘空间失败: %w", err)
+	}
+	return file.Close()
+}
+
+// writeChunkAt 将上传的分块直接写入预分配文件中对应的偏移量
+// 分块大小必须与会话的分块大小一致；先按 algo 计算哈希值并通过 verify 后才写入，校验失败时不会覆盖已完成分块的数据
+// 覆盖已完成的分块时先取消其完成标记并记录到磁盘日志，写入中断后重启不会把写了一半的分块当作已完成
+// 调用方需通过 info.LockChunk 锁定该分块
+func writeChunkAt(file *multipart.FileHeader, info *models.UploadInfo, chunkIndex int, algo string, verify func(hash string) error) (string, error) {
+	if file.Size != info.ChunkLength(chunkIndex) {
+		return "", fmt.Errorf("%w: 应为 %d 字节，实际 %d 字节", errChunkSizeMismatch, info.ChunkLength(chunkIndex), file.Size)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	// 上传的分块已由 gin 保存在内存或临时文件中，先读一遍计算哈希值
+	hasher := utils.NewHash(algo)
+	if _, err := io.Copy(hasher, src); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if err := verify(hash); err != nil {
+		return hash, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return hash, err
+	}
+
+	info.Mu.Lock()
+	wasCompleted := info.Completed[chunkIndex]
+	info.Completed[chunkIndex] = false
+	delete(info.ChunkHashes, chunkIndex)
+	info.Mu.Unlock()
+	if wasCompleted {
+		if err := models.SaveUploadInfo(info); err != nil {
+			return hash, err
+		}
+	}
+
+	// 预分配文件可能在服务重启后丢失，此时重新创建，未写入的区域为空洞
+	dst, err := os.OpenFile(partFilePath(info), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return hash, err
+	}
+	defer dst.Close()
+
+	offset := int64(chunkIndex) * info.ChunkSize
+	if _, err := io.Copy(io.NewOffsetWriter(dst, offset), src); err != nil {
+		return hash, err
+	}
+	// 落盘后再标记分块完成，避免断电后记录与数据不一致
+	return hash, dst.Sync()
+}
+
+// stagePartFile 读取一遍预分配文件计算哈希值，再将其导入存储的暂存区，预分配文件保留到上传完成后再删除
+// 存储后端可以直接引用本地文件时无需复制数据，否则在上传的同时计算哈希值
+func stagePartFile(ctx context.Context, info *models.UploadInfo) (*stagedObject, error) {
+	file, err := os.Open(partFilePath(info))
+	if err != nil {
+		return nil, fmt.Errorf("打开上传文件失败: %w", err)
+	}
+	defer file.Close()
+
+	importer, ok := fileStorage.(storage.FileImporter)
+	if !ok {
+		return stageObject(ctx, info.FileID, io.LimitReader(file, info.TotalSize), info.TotalSize, info.HashAlgorithm())
+	}
+
+	hasher := newContentHasher(info.HashAlgorithm())
+	buf := make([]byte, 4*1024*1024)
+	if _, err := io.CopyBuffer(hasher, io.LimitReader(file, info.TotalSize), buf); err != nil {
+		return nil, fmt.Errorf("读取上传文件失败: %w", err)
+	}
+
+	key := stagingKey(info.FileID)
+	if err := importer.Import(ctx, key, file.Name()); err != nil {
+		return nil, err
+	}
+	return hasher.staged(key), nil
+}
+
+// chunkSectionHash 按 algo 计算预分配文件中指定分块的哈希值
+func chunkSectionHash(info *models.UploadInfo, chunkIndex int, algo string) (string, error) {
+	file, err := os.Open(partFilePath(info))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	section := io.NewSectionReader(file, int64(chunkIndex)*info.ChunkSize, info.ChunkLength(chunkIndex))
+	return utils.CalculateReaderHash(section, algo)
+}
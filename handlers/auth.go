@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// nodeSignatureMaxSkew 签名时间戳与服务器时间允许的最大偏差
+const nodeSignatureMaxSkew = 5 * time.Minute
+
+var (
+	errUnknownNode        = errors.New("节点未注册")
+	errMissingCredential  = errors.New("缺少节点凭证")
+	errInvalidCredential  = errors.New("节点凭证无效")
+	errSignatureExpired   = errors.New("签名时间戳已过期")
+	errMalformedTimestamp = errors.New("签名时间戳格式不正确")
+)
+
+// authenticateNode 校验请求中携带的节点凭证
+// 支持两种方式（WebSocket 客户端无法设置请求头时可使用查询参数）：
+//  1. 令牌：Authorization: Bearer <secret> 或查询参数 token
+//  2. 签名：X-Node-Timestamp/ts 为 Unix 秒时间戳，X-Node-Signature/sig 为 HMAC-SHA256(secret, uid:ts)
+//
+// 校验失败时返回应使用的 HTTP 状态码
+func authenticateNode(c *gin.Context, uid string) (int, error) {
+	if _, exists, err := models.GetNode(uid); err != nil {
+		return http.StatusInternalServerError, err
+	} else if !exists {
+		return http.StatusForbidden, errUnknownNode
+	}
+
+	secret, exists, err := models.GetNodeSecret(uid)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !exists {
+		return http.StatusForbidden, errInvalidCredential
+	}
+
+	if token := bearerToken(c); token != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return http.StatusUnauthorized, errInvalidCredential
+		}
+		return http.StatusOK, nil
+	}
+
+	ts := headerOrQuery(c, "X-Node-Timestamp", "ts")
+	sig := headerOrQuery(c, "X-Node-Signature", "sig")
+	if ts == "" || sig == "" {
+		return http.StatusUnauthorized, errMissingCredential
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return http.StatusUnauthorized, errMalformedTimestamp
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > nodeSignatureMaxSkew || skew < -nodeSignatureMaxSkew {
+		return http.StatusUnauthorized, errSignatureExpired
+	}
+
+	expected := utils.SignNodeToken(secret, uid, ts)
+	if !hmac.Equal([]byte(strings.ToLower(sig)), []byte(expected)) {
+		return http.StatusUnauthorized, errInvalidCredential
+	}
+
+	return http.StatusOK, nil
+}
+
+// bearerToken 从 Authorization 请求头或 token 查询参数中获取令牌
+func bearerToken(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return c.Query("token")
+}
+
+// hasNodeCredential 请求是否携带了节点凭证（令牌或签名），不校验其是否有效
+func hasNodeCredential(c *gin.Context) bool {
+	return bearerToken(c) != "" || headerOrQuery(c, "X-Node-Signature", "sig") != ""
+}
+
+// headerOrQuery 优先读取请求头，其次读取查询参数
+func headerOrQuery(c *gin.Context, header, query string) string {
+	if v := c.GetHeader(header); v != "" {
+		return v
+	}
+	return c.Query(query)
+}
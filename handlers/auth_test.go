@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthenticateNode(t *testing.T) {
+	newTestEnv(t)
+	secret, err := models.CreateNode(&models.NodeInfo{UID: "n1", Name: "n1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 已注册但没有凭证的节点（早期版本注册的节点）
+	if err := models.SaveNode(&models.NodeInfo{UID: "legacy", Name: "legacy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-nodeSignatureMaxSkew-time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(nodeSignatureMaxSkew+time.Minute).Unix(), 10)
+	earlier := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	sig := utils.SignNodeToken(secret, "n1", now)
+
+	tests := []struct {
+		name    string
+		uid     string
+		headers map[string]string
+		query   url.Values
+		status  int
+		err     error
+	}{
+		{"令牌请求头", "n1", map[string]string{"Authorization": "Bearer " + secret}, nil, http.StatusOK, nil},
+		{"令牌查询参数", "n1", nil, url.Values{"token": {secret}}, http.StatusOK, nil},
+		{"签名请求头", "n1", map[string]string{"X-Node-Timestamp": now, "X-Node-Signature": sig}, nil, http.StatusOK, nil},
+		{"签名查询参数", "n1", nil, url.Values{"ts": {now}, "sig": {sig}}, http.StatusOK, nil},
+		{"签名不区分大小写", "n1", nil, url.Values{"ts": {now}, "sig": {strings.ToUpper(sig)}}, http.StatusOK, nil},
+		{"请求头优先于查询参数", "n1", map[string]string{"X-Node-Signature": sig}, url.Values{"ts": {now}, "sig": {"wrong"}}, http.StatusOK, nil},
+
+		{"令牌错误", "n1", map[string]string{"Authorization": "Bearer wrong"}, nil, http.StatusUnauthorized, errInvalidCredential},
+		{"令牌错误时不再校验签名", "n1", map[string]string{"Authorization": "Bearer wrong", "X-Node-Timestamp": now, "X-Node-Signature": sig}, nil, http.StatusUnauthorized, errInvalidCredential},
+		{"没有凭证", "n1", nil, nil, http.StatusUnauthorized, errMissingCredential},
+		{"缺少时间戳", "n1", nil, url.Values{"sig": {sig}}, http.StatusUnauthorized, errMissingCredential},
+		{"时间戳格式不正确", "n1", nil, url.Values{"ts": {"abc"}, "sig": {sig}}, http.StatusUnauthorized, errMalformedTimestamp},
+		{"时间戳已过期", "n1", nil, url.Values{"ts": {stale}, "sig": {utils.SignNodeToken(secret, "n1", stale)}}, http.StatusUnauthorized, errSignatureExpired},
+		{"时间戳超前过多", "n1", nil, url.Values{"ts": {future}, "sig": {utils.SignNodeToken(secret, "n1", future)}}, http.StatusUnauthorized, errSignatureExpired},
+		{"签名与时间戳不符", "n1", nil, url.Values{"ts": {earlier}, "sig": {sig}}, http.StatusUnauthorized, errInvalidCredential},
+		{"签名使用了其他节点标识", "n1", nil, url.Values{"ts": {now}, "sig": {utils.SignNodeToken(secret, "n2", now)}}, http.StatusUnauthorized, errInvalidCredential},
+		{"签名使用了错误的密钥", "n1", nil, url.Values{"ts": {now}, "sig": {utils.SignNodeToken("wrong", "n1", now)}}, http.StatusUnauthorized, errInvalidCredential},
+		{"未注册的节点", "n2", map[string]string{"Authorization": "Bearer " + secret}, nil, http.StatusForbidden, errUnknownNode},
+		{"节点没有凭证", "legacy", map[string]string{"Authorization": "Bearer " + secret}, nil, http.StatusForbidden, errInvalidCredential},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/socket/node/"+tt.uid+"?"+tt.query.Encode(), nil)
+			for k, v := range tt.headers {
+				c.Request.Header.Set(k, v)
+			}
+
+			status, err := authenticateNode(c, tt.uid)
+			if status != tt.status || !errors.Is(err, tt.err) {
+				t.Fatalf("authenticateNode(%q) = %d, %v，期望 %d, %v", tt.uid, status, err, tt.status, tt.err)
+			}
+		})
+	}
+}
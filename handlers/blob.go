@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/storage"
+	"com.example/relay/utils"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+)
+
+// errFileExists 同名文件已存在且内容不同
+var errFileExists = errors.New("同名文件已存在，如需覆盖请设置 overwrite=true")
+
+// mimeDetectSize 识别 MIME 类型时读取的文件头长度，与 mimetype 库的默认读取上限一致
+const mimeDetectSize = 3072
+
+// 存储内部使用的顶级目录
+const (
+	blobsDir   = "blobs"   // 内容寻址的 blob 对象
+	stagingDir = "staging" // 写入中的暂存对象
+)
+
+// blobKey blob 对象键，按哈希前两位分目录，避免单个目录下文件过多
+func blobKey(hash string) string {
+	return blobsDir + "/" + hash[:2] + "/" + hash
+}
+
+// stagingKey 写入中的对象键，计算出内容哈希后再转存为 blob
+func stagingKey(id string) string {
+	return stagingDir + "/" + id
+}
+
+// checkFileConflict 检查写入 name 是否会覆盖内容不同的已有文件，hash 为按 algo 计算的内容哈希值
+// hash 为空表示内容未知，此时只要同名文件存在即视为冲突
+func checkFileConflict(name, hash, algo string, overwrite bool) error {
+	if overwrite {
+		return nil
+	}
+
+	meta, exists, err := models.GetFile(name)
+	if err != nil {
+		return err
+	}
+	if exists && (hash == "" || !sameContent(meta, hash, algo)) {
+		return errFileExists
+	}
+	return nil
+}
+
+// sameContent 已索引文件的内容哈希是否为 hash
+// 索引只记录MD5，其他算法通过已保存的哈希清单比较，清单不存在时视为不同
+func sameContent(meta *models.FileMeta, hash, algo string) bool {
+	if algo == utils.HashMD5 {
+		return meta.Hash == hash
+	}
+	manifest, ok := cachedManifest(blobManifestSource(meta.Hash, meta.Size, algo), 0)
+	return ok && manifest.FileHash == hash
+}
+
+// stagedObject 已写入存储暂存区、尚未纳入内容寻址存储的对象
+type stagedObject struct {
+	key       string                 // 暂存对象键
+	hash      string                 // 内容的MD5哈希值，作为内容寻址存储的键
+	digest    string                 // 按客户端声明的算法计算的内容哈希值，用于完整性校验
+	size      int64                  // 实际写入的字节数
+	manifests []*models.HashManifest // 写入时同时计算的哈希清单，每种算法一份
+}
+
+// stageObject 将数据流写入存储的暂存区
+// 写入的同时计算内容的MD5哈希值、按 algo 计算的哈希值以及两种算法下各分块大小的分块哈希值，之后无需再读取一遍数据
+func stageObject(ctx context.Context, id string, r io.Reader, size int64, algo string) (*stagedObject, error) {
+	key := stagingKey(id)
+	hasher := newContentHasher(algo)
+	if err := fileStorage.Put(ctx, key, io.TeeReader(r, hasher), size); err != nil {
+		return nil, err
+	}
+	return hasher.staged(key), nil
+}
+
+// contentHasher 在数据流经时计算内容的MD5哈希值、按客户端声明的算法计算的哈希值，以及两种算法下的哈希清单
+type contentHasher struct {
+	algo      string
+	algos     []string
+	hashers   []hash.Hash
+	recorders []*manifestRecorder
+	counter   countingWriter
+	writer    io.Writer
+}
+
+// newContentHasher 创建内容哈希计算器，algo 为MD5时只计算一种算法
+func newContentHasher(algo string) *contentHasher {
+	h := &contentHasher{algo: algo, algos: []string{utils.HashMD5}}
+	if algo != utils.HashMD5 {
+		h.algos = append(h.algos, algo)
+	}
+
+	writers := []io.Writer{&h.counter}
+	for _, a := range h.algos {
+		hasher := utils.NewHash(a)
+		recorder := newManifestRecorder(relayConfig.Download.ManifestChunkSizes, a)
+		h.hashers = append(h.hashers, hasher)
+		h.recorders = append(h.recorders, recorder)
+		writers = append(writers, hasher, recorder)
+	}
+	h.writer = io.MultiWriter(writers...)
+	return h
+}
+
+// Write 写入数据
+func (h *contentHasher) Write(p []byte) (int, error) {
+	return h.writer.Write(p)
+}
+
+// staged 数据写入完毕后生成暂存对象的描述，key 为暂存对象键
+func (h *contentHasher) staged(key string) *stagedObject {
+	staged := &stagedObject{
+		key:  key,
+		hash: hex.EncodeToString(h.hashers[0].Sum(nil)),
+		size: h.counter.n,
+	}
+	for i, a := range h.algos {
+		digest := hex.EncodeToString(h.hashers[i].Sum(nil))
+		if a == h.algo {
+			staged.digest = digest
+		}
+		staged.manifests = append(staged.manifests, h.recorders[i].manifest(blobManifestSource(staged.hash, staged.size, a), digest))
+	}
+	return staged
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// commitObject 将暂存对象纳入内容寻址存储并以 name 建立索引，暂存对象随后被删除
+// 相同内容已存在时直接复用，返回值 deduplicated 表示是否复用了已有内容
+// 上传开始时的冲突检查之后可能有其他上传写入了同名文件，不允许覆盖时在索引锁内再检查一次，冲突时返回 errFileExists
+func commitObject(ctx context.Context, staged *stagedObject, name, owner string, overwrite bool) (meta *models.FileMeta, deduplicated bool, err error) {
+	models.FilesMutex.Lock()
+	defer models.FilesMutex.Unlock()
+	defer fileStorage.Delete(ctx, staged.key)
+
+	if err := checkFileConflict(name, staged.hash, utils.HashMD5, overwrite); err != nil {
+		return nil, false, err
+	}
+
+	// 新内容在写入 blob 时识别一次 MIME 类型，已有内容沿用 blob 记录中的类型
+	var mimeType string
+	if _, err := fileStorage.Stat(ctx, blobKey(staged.hash)); err == nil {
+		deduplicated = true
+	} else if !errors.Is(err, storage.ErrNotExist) {
+		return nil, false, err
+	} else if err := fileStorage.Compose(ctx, blobKey(staged.hash), []string{staged.key}); err != nil {
+		return nil, false, err
+	} else {
+		mimeType = detectMimeType(ctx, blobKey(staged.hash))
+	}
+
+	meta, err = linkFile(ctx, name, staged.hash, staged.size, owner, mimeType)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// 已有内容的清单可能包含更多分块大小，不覆盖
+	for _, manifest := range staged.manifests {
+		src := blobManifestSource(staged.hash, staged.size, manifest.Algo)
+		if _, ok := cachedManifest(src, 0); ok {
+			continue
+		}
+		if err := models.SaveManifest(manifest); err != nil {
+			fmt.Printf("保存哈希清单 %s 失败: %v\n", src.key, err)
+		}
+	}
+	return meta, deduplicated, nil
+}
+
+// detectMimeType 读取对象开头的数据识别 MIME 类型，失败时返回空字符串
+func detectMimeType(ctx context.Context, key string) string {
+	reader, err := fileStorage.Get(ctx, key, 0, mimeDetectSize)
+	if err != nil {
+		fmt.Printf("读取 %s 识别文件类型失败: %v\n", key, err)
+		return ""
+	}
+	defer reader.Close()
+
+	mtype, err := mimetype.DetectReader(reader)
+	if err != nil {
+		fmt.Printf("读取 %s 识别文件类型失败: %v\n", key, err)
+		return ""
+	}
+	return mtype.String()
+}
+
+// deleteBlob 删除不再被引用的 blob 及其哈希清单，调用方需持有 models.FilesMutex
+// 仍有下载会话在读取该 blob 时暂不删除，由最后一个会话结束时的 releaseDownloads 删除
+func deleteBlob(ctx context.Context, hash string) {
+	if models.BlobInDownload(hash) {
+		return
+	}
+	if err := fileStorage.Delete(ctx, blobKey(hash)); err != nil {
+		fmt.Printf("删除 blob %s 失败: %v\n", hash, err)
+	}
+	if err := models.RemoveBlobManifests(hash); err != nil {
+		fmt.Printf("删除 blob %s 的哈希清单失败: %v\n", hash, err)
+	}
+}
+
+// releaseDownloads 下载会话结束后删除其间失去所有文件引用的 blob
+func releaseDownloads(ctx context.Context, infos ...*models.DownloadInfo) {
+	models.FilesMutex.Lock()
+	defer models.FilesMutex.Unlock()
+
+	releaseDownloadBlobs(ctx, infos...)
+}
+
+// releaseDownloadBlobs 同 releaseDownloads，调用方需持有 models.FilesMutex
+func releaseDownloadBlobs(ctx context.Context, infos ...*models.DownloadInfo) {
+	for _, info := range infos {
+		if info == nil || info.BlobHash == "" {
+			continue
+		}
+		// 会话期间 blob 可能又被其他文件引用
+		if _, exists, err := models.GetBlob(info.BlobHash); err != nil || exists {
+			continue
+		}
+		deleteBlob(ctx, info.BlobHash)
+	}
+}
+
+// linkExistingBlob 秒传：内容已存在于存储中时直接以 name 建立索引，无需再上传数据
+// 与 commitObject 相同，不允许覆盖时在索引锁内检查同名文件，冲突时返回 errFileExists
+func linkExistingBlob(ctx context.Context, name, hash string, size int64, owner string, overwrite bool) (*models.FileMeta, bool, error) {
+	models.FilesMutex.Lock()
+	defer models.FilesMutex.Unlock()
+
+	if err := checkFileConflict(name, hash, utils.HashMD5, overwrite); err != nil {
+		return nil, false, err
+	}
+
+	blob, exists, err := models.GetBlob(hash)
+	if err != nil || !exists || blob.Size != size {
+		return nil, false, err
+	}
+	if _, err := fileStorage.Stat(ctx, blobKey(hash)); err != nil {
+		return nil, false, nil
+	}
+
+	meta, err := linkFile(ctx, name, hash, size, owner, blob.MimeType)
+	return meta, err == nil, err
+}
+
+// linkFile 建立文件名索引并删除不再被引用的 blob，调用方需持有 models.FilesMutex
+func linkFile(ctx context.Context, name, hash string, size int64, owner, mimeType string) (*models.FileMeta, error) {
+	now := time.Now()
+	meta := &models.FileMeta{
+		Name:       name,
+		Hash:       hash,
+		Size:       size,
+		MimeType:   mimeType,
+		Owner:      owner,
+		CreatedAt:  now,
+		ModifiedAt: now,
+	}
+
+	orphan, err := models.LinkFile(meta)
+	if err != nil {
+		return nil, err
+	}
+	// 同名旧文件被纳入索引后不再使用按文件名保存的清单
+	models.RemoveLegacyManifests(name)
+	if orphan != "" {
+		deleteBlob(ctx, orphan)
+	}
+	return meta, nil
+}
+
+// removeFile 删除文件名索引，内容不再被任何文件引用时删除 blob，调用方需持有 models.FilesMutex
+// 未纳入索引的旧文件直接从存储中删除
+func removeFile(ctx context.Context, name string) (bool, error) {
+	orphan, exists, err := models.UnlinkFile(name)
+	if err != nil {
+		return exists, err
+	}
+	if orphan != "" {
+		deleteBlob(ctx, orphan)
+	}
+	if exists {
+		return true, nil
+	}
+
+	if _, err := fileStorage.Stat(ctx, name); errors.Is(err, storage.ErrNotExist) {
+		return false, nil
+	}
+	models.RemoveLegacyManifests(name)
+	return true, fileStorage.Delete(ctx, name)
+}
+
+// reservedPrefixes 存储内部使用的顶级目录，客户端文件名不能以这些目录开头
+func reservedPrefixes() []string {
+	prefixes := []string{blobsDir, stagingDir}
+	// 临时目录位于存储根目录之下时同样需要保留
+	if rel, err := filepath.Rel(relayConfig.Storage.UploadsDir, relayConfig.Storage.TempDir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		prefixes = append(prefixes, filepath.ToSlash(rel))
+	}
+	return prefixes
+}
+
+// cleanFileName 规范化客户端提供的文件名，拒绝路径穿越和存储内部保留的名称
+func cleanFileName(name string) (string, error) {
+	cleaned, err := utils.CleanFilePath(name)
+	if err != nil {
+		return "", err
+	}
+
+	// 按不区分大小写比较，避免在大小写不敏感的文件系统上绕过
+	lower := strings.ToLower(cleaned)
+	for _, prefix := range reservedPrefixes() {
+		if prefix = strings.ToLower(prefix); lower == prefix || strings.HasPrefix(lower, prefix+"/") {
+			return "", fmt.Errorf("%w: %s 为保留名称", utils.ErrInvalidPath, prefix)
+		}
+	}
+	return cleaned, nil
+}
+
+// bindFileName 校验请求中的文件名，不合法时返回 400
+func bindFileName(c *gin.Context, name string) (string, bool) {
+	cleaned, err := cleanFileName(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return "", false
+	}
+	return cleaned, true
+}
+
+// renameFile 将文件从 from 移动到 to，返回 from 是否存在，调用方需持有 models.FilesMutex
+// 目标已存在且内容不同、又不允许覆盖时返回 errFileExists；未纳入索引的旧文件在存储中复制后删除
+func renameFile(ctx context.Context, from, to string, overwrite bool) (bool, error) {
+	meta, exists, err := models.GetFile(from)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		if _, err := fileStorage.Stat(ctx, from); err != nil {
+			if errors.Is(err, storage.ErrNotExist) {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+
+	// 目标在索引中时按内容判断是否冲突，不在索引中时存储里的旧文件同样视为冲突
+	if !overwrite {
+		target, indexed, err := models.GetFile(to)
+		if err != nil {
+			return true, err
+		}
+		if indexed && (!exists || target.Hash != meta.Hash) {
+			return true, errFileExists
+		}
+		if !indexed {
+			if _, err := fileStorage.Stat(ctx, to); err == nil {
+				return true, errFileExists
+			} else if !errors.Is(err, storage.ErrNotExist) {
+				return true, err
+			}
+		}
+	}
+
+	if exists {
+		orphan, err := models.RenameFile(from, to)
+		if orphan != "" {
+			deleteBlob(ctx, orphan)
+		}
+		return true, err
+	}
+
+	// 旧文件：先移除目标的索引，再复制存储对象
+	orphan, _, err := models.UnlinkFile(to)
+	if err != nil {
+		return true, err
+	}
+	if orphan != "" {
+		deleteBlob(ctx, orphan)
+	}
+	if err := fileStorage.Compose(ctx, to, []string{from}); err != nil {
+		return true, err
+	}
+	models.RemoveLegacyManifests(from)
+	models.RemoveLegacyManifests(to)
+	return true, fileStorage.Delete(ctx, from)
+}
+
+// resolveFile 解析文件名对应的存储对象键
+// 兼容引入内容寻址存储之前直接以文件名存放的旧文件，此时返回的 meta 为 nil
+func resolveFile(name string) (string, *models.FileMeta, error) {
+	meta, exists, err := models.GetFile(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if exists {
+		return blobKey(meta.Hash), meta, nil
+	}
+	return name, nil, nil
+}
+
+// serveFile 发送存储中的对象，由 http.ServeContent 处理 Range 和条件请求
+// blob 对象键不含扩展名，需按原文件名设置 Content-Type；以内容的MD5哈希值作为强 ETag，
+// meta 不为空时以文件索引的修改时间作为 Last-Modified，多个文件共用同一 blob 时互不影响；
+// 未纳入索引的旧文件的哈希值取自其哈希清单，清单不存在或文件已修改时读取一遍文件计算并保存
+func serveFile(c *gin.Context, info *storage.ObjectInfo, name string, meta *models.FileMeta) {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		c.Header("Content-Type", contentType)
+	} else if meta != nil && meta.MimeType != "" {
+		c.Header("Content-Type", meta.MimeType)
+	}
+
+	modTime := info.ModTime
+	if meta != nil {
+		c.Header("ETag", `"`+meta.Hash+`"`)
+		modTime = meta.ModifiedAt
+	} else if manifest, err := loadManifest(c.Request.Context(), legacyManifestSource(name, info.Size, info.ModTime, utils.HashMD5), 0); err != nil {
+		fmt.Printf("计算 %s 的哈希值失败，不设置 ETag: %v\n", name, err)
+	} else {
+		c.Header("ETag", `"`+manifest.FileHash+`"`)
+	}
+
+	content := storage.NewReadSeeker(c.Request.Context(), fileStorage, info.Key, info.Size)
+	defer content.Close()
+
+	http.ServeContent(c.Writer, c.Request, name, modTime, content)
+}
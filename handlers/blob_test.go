@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"com.example/relay/config"
+	"com.example/relay/models"
+	"com.example/relay/storage"
+	"com.example/relay/store"
+	"com.example/relay/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestEnv 使用临时目录中的本地存储和数据库替换处理器的配置和存储后端，测试结束后恢复
+func newTestEnv(t *testing.T) *config.Config {
+	t.Helper()
+	root := t.TempDir()
+	cfg := config.Default()
+	cfg.Storage.UploadsDir = root
+	cfg.Storage.TempDir = filepath.Join(root, "temp")
+	cfg.Storage.DBPath = filepath.Join(t.TempDir(), "relay.db")
+
+	st, err := storage.NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(cfg.Storage.DBPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.LoadUsage(); err != nil {
+		t.Fatal(err)
+	}
+
+	savedConfig, savedStorage := relayConfig, fileStorage
+	relayConfig, fileStorage = cfg, st
+	t.Cleanup(func() {
+		relayConfig, fileStorage = savedConfig, savedStorage
+		store.Close()
+	})
+	return cfg
+}
+
+// putTestFile 以 name 为名写入一个纳入索引的文件
+func putTestFile(t *testing.T, name, content, owner string) *models.FileMeta {
+	t.Helper()
+	ctx := context.Background()
+	staged, err := stageObject(ctx, "test-"+utils.GenerateFileID(name, int64(len(content))), strings.NewReader(content), int64(len(content)), utils.HashMD5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, _, err := commitObject(ctx, staged, name, owner, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+func TestCleanFileName(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.Default()
+	cfg.Storage.UploadsDir = root
+	cfg.Storage.TempDir = filepath.Join(root, "temp")
+	saved := relayConfig
+	relayConfig = cfg
+	defer func() { relayConfig = saved }()
+
+	tests := []struct {
+		name string
+		in   string
+		want string // 为空表示应当拒绝
+	}{
+		{"普通文件名", "dir/a.txt", "dir/a.txt"},
+		{"保留目录名作为前缀的普通文件", "blobsx/a.txt", "blobsx/a.txt"},
+		{"保留目录名出现在子目录", "dir/blobs/a.txt", "dir/blobs/a.txt"},
+
+		{"blob 目录", "blobs/ab/abcdef", ""},
+		{"blob 目录本身", "blobs", ""},
+		{"暂存目录", "staging/upload-1", ""},
+		{"大写绕过", "Staging/upload-1", ""},
+		{"反斜杠绕过", `blobs\ab\abcdef`, ""},
+		{"点路径段绕过", "./blobs/ab", ""},
+		{"存储根目录下的临时目录", "temp/abc-0", ""},
+		{"上级目录", "../a.txt", ""},
+		{"绝对路径", "/etc/passwd", ""},
+		{"盘符路径", `C:\a.txt`, ""},
+		{"设备名", "NUL.txt", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanFileName(tt.in)
+			if tt.want == "" {
+				if !errors.Is(err, utils.ErrInvalidPath) {
+					t.Fatalf("cleanFileName(%q) = %q, %v，期望 ErrInvalidPath", tt.in, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("cleanFileName(%q) = %q, %v，期望 %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanFileNameTempDirOutsideRoot(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.UploadsDir = t.TempDir()
+	cfg.Storage.TempDir = t.TempDir()
+	saved := relayConfig
+	relayConfig = cfg
+	defer func() { relayConfig = saved }()
+
+	// 临时目录不在存储根目录之下时，同名目录是普通文件名
+	if got, err := cleanFileName("temp/a.txt"); err != nil || got != "temp/a.txt" {
+		t.Fatalf("cleanFileName = %q, %v", got, err)
+	}
+}
+
+func TestServeFileETag(t *testing.T) {
+	cfg := newTestEnv(t)
+	putTestFile(t, "indexed.txt", "hello world", "")
+	if err := os.WriteFile(filepath.Join(cfg.Storage.UploadsDir, "legacy.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// "hello world" 的MD5哈希值
+	etag := `"5eb63bbbe01eeed093cb22bb8f5acdc3"`
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/file/download", SimpleDownload)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{"完整下载", nil, http.StatusOK, "hello world"},
+		{"ETag 匹配时返回 304", map[string]string{"If-None-Match": etag}, http.StatusNotModified, ""},
+		{"ETag 不匹配时返回完整内容", map[string]string{"If-None-Match": `"other"`}, http.StatusOK, "hello world"},
+		{"区间请求", map[string]string{"Range": "bytes=6-"}, http.StatusPartialContent, "world"},
+		{"If-Range 匹配时返回区间", map[string]string{"Range": "bytes=0-4", "If-Range": etag}, http.StatusPartialContent, "hello"},
+		{"If-Range 不匹配时返回完整内容", map[string]string{"Range": "bytes=0-4", "If-Range": `"other"`}, http.StatusOK, "hello world"},
+	}
+
+	for _, name := range []string{"indexed.txt", "legacy.txt"} {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/file/download?file_name="+name, nil)
+				for k, v := range tt.headers {
+					req.Header.Set(k, v)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				if w.Code != tt.status || w.Body.String() != tt.body {
+					t.Fatalf("响应 = %d %q，期望 %d %q", w.Code, w.Body.String(), tt.status, tt.body)
+				}
+				if got := w.Header().Get("ETag"); got != etag {
+					t.Fatalf("ETag = %s，期望 %s", got, etag)
+				}
+			})
+		}
+	}
+}
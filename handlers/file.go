@@ -1,20 +1,31 @@
 package handlers
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"com.example/relay/config"
 	"com.example/relay/models"
+	"com.example/relay/storage"
 	"com.example/relay/utils"
 	"github.com/gin-gonic/gin"
 )
 
+// ChunkHeaders 分块下载响应中浏览器端需要读取的响应头，用于 CORS 配置
+var ChunkHeaders = []string{"Content-Range", "X-Chunk-Hash", "X-Chunk-Hash-Algo", "X-Merkle-Root", "X-Merkle-Proof"}
+
 // SetupFileRoutes 设置文件相关路由
 func SetupFileRoutes(router *gin.RouterGroup) {
 	// 初始化上传
@@ -25,6 +36,7 @@ func SetupFileRoutes(router *gin.RouterGroup) {
 
 	// 完成上传，合并文件
 	router.POST("/upload/complete", CompleteUpload)
+	router.DELETE("/upload/:file_id", AbortUpload)
 
 	// 查询上传状态
 	router.GET("/upload/status", CheckUploadStatus)
@@ -35,15 +47,28 @@ func SetupFileRoutes(router *gin.RouterGroup) {
 	// 新增下载相关路由
 	// 简单下载接口
 	router.GET("/download", SimpleDownload)
+	router.HEAD("/download", SimpleDownload)
 
 	// 初始化大文件下载
 	router.GET("/download/init", InitDownload)
 
 	// 分块下载接口
 	router.GET("/download/chunk", DownloadChunk)
+	router.DELETE("/download/:file_id", ReleaseDownload)
 
 	// 查询下载元信息
 	router.GET("/download/info", GetDownloadInfo)
+
+	// 文件列表
+	router.GET("/list", ListFiles)
+
+	// 文件管理：删除、重命名、移动
+	router.DELETE("/:name", DeleteFile)
+	router.POST("/rename", RenameFile)
+	router.POST("/move", MoveFile)
+
+	// tus 1.0 可续传上传协议
+	SetupTusRoutes(router.Group("/tus"))
 }
 
 // SimpleUpload 简单上传处理
@@ -57,19 +82,62 @@ func SimpleUpload(c *gin.Context) {
 	}
 
 	// 限制简单上传的文件大小
-	if file.Size > 10<<20 { // 10 MiB
+	if file.Size > relayConfig.Upload.SimpleMaxSize {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":        "文件过大，请使用分块上传接口",
-			"max_size":     "10MB",
+			"max_size":     fmt.Sprintf("%.2f MB", float64(relayConfig.Upload.SimpleMaxSize)/(1024*1024)),
 			"current_size": fmt.Sprintf("%.2f MB", float64(file.Size)/(1024*1024)),
 		})
 		return
 	}
 
-	dst := filepath.Join(config.UploadsDir, file.Filename)
+	fileName, ok := bindFileName(c, file.Filename)
+	if !ok {
+		return
+	}
+
+	owner, status, err := uploadOwner(c, c.PostForm("owner"))
+	if err != nil {
+		c.JSON(status, gin.H{
+			"error": "节点认证失败: " + err.Error(),
+		})
+		return
+	}
+
+	if err := checkDirectUpload(owner, fileName, file.Size); err != nil {
+		c.JSON(quotaStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "读取上传文件失败: " + err.Error(),
+		})
+		return
+	}
+	defer src.Close()
+
+	// 先写入暂存区并计算哈希，再纳入内容寻址存储
+	ctx := c.Request.Context()
+	staged, err := stageObject(ctx, "simple-"+utils.GenerateFileID(fileName, file.Size), src, file.Size, utils.DefaultHashAlgo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "保存文件失败: " + err.Error(),
+		})
+		return
+	}
 
-	// 保存文件
-	if err := c.SaveUploadedFile(file, dst); err != nil {
+	_, deduplicated, err := commitObject(ctx, staged, fileName, owner, c.PostForm("overwrite") == "true")
+	if errors.Is(err, errFileExists) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "保存文件失败: " + err.Error(),
 		})
@@ -77,9 +145,11 @@ func SimpleUpload(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "文件上传成功",
-		"file":    file.Filename,
-		"size":    file.Size,
+		"message":      "文件上传成功",
+		"file":         fileName,
+		"size":         file.Size,
+		"file_hash":    staged.hash,
+		"deduplicated": deduplicated,
 	})
 }
 
@@ -87,16 +157,30 @@ func SimpleUpload(c *gin.Context) {
 func InitUpload(c *gin.Context) {
 	fileName := c.PostForm("file_name")
 	fileSizeStr := c.PostForm("file_size")
-	chunkSizeStr := c.PostForm("chunk_size")
-	fileHash := c.PostForm("file_hash") // 接收文件哈希值，MD5
+	chunkSizeStr := c.DefaultPostForm("chunk_size", strconv.FormatInt(relayConfig.Upload.DefaultChunkSize, 10))
+	fileHash := c.PostForm("file_hash") // 接收文件哈希值，算法由 hash_algo 指定
 
-	if fileName == "" || fileSizeStr == "" || chunkSizeStr == "" {
+	if fileName == "" || fileSizeStr == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "参数不完整",
 		})
 		return
 	}
 
+	fileName, ok := bindFileName(c, fileName)
+	if !ok {
+		return
+	}
+
+	// 上传者（节点ID），用于计算配额和区分不同节点的同名文件，需携带该节点的凭证
+	owner, status, err := uploadOwner(c, c.PostForm("owner"))
+	if err != nil {
+		c.JSON(status, gin.H{
+			"error": "节点认证失败: " + err.Error(),
+		})
+		return
+	}
+
 	fileSize, err := strconv.ParseInt(fileSizeStr, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -106,38 +190,163 @@ func InitUpload(c *gin.Context) {
 	}
 
 	chunkSize, err := strconv.ParseInt(chunkSizeStr, 10, 64)
+	if err != nil || chunkSize <= 0 || chunkSize > relayConfig.Upload.MaxChunkSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          "chunk_size 参数格式不正确",
+			"max_chunk_size": relayConfig.Upload.MaxChunkSize,
+		})
+		return
+	}
+
+	// file_hash 和 chunk_hash 使用的哈希算法，默认为MD5以兼容浏览器端的 SparkMD5
+	hashAlgo, err := utils.ParseHashAlgo(c.PostForm("hash_algo"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "chunk_size 参数格式不正确",
+			"error": err.Error(),
 		})
 		return
 	}
+	fileHash = strings.ToLower(fileHash)
+
+	// 提供 Merkle 根哈希时每个分块需附带证明，分块到达时即可单独校验
+	var rootHash string
+	if root := c.PostForm("merkle_root"); root != "" {
+		if fileSize == 0 {
+			err = fmt.Errorf("空文件没有分块，不能提供 merkle_root")
+		} else {
+			rootHash, err = parseMerkleRoot(root, hashAlgo)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
 
 	// 生成文件唯一标识
+	// 提供了文件哈希时标识是确定性的，重复初始化同一文件会命中已有会话并续传
 	fileID := utils.GenerateFileID(fileName, fileSize)
+	// 其他算法的哈希值带上算法名称，避免与MD5会话的标识混淆
+	if fileHash != "" {
+		idHash := fileHash
+		if hashAlgo != utils.HashMD5 {
+			idHash = hashAlgo + ":" + fileHash
+		}
+		fileID = utils.GenerateUploadID(idHash, fileName, fileSize, owner)
+	}
 
 	// 计算总块数
 	totalChunks := int((fileSize + chunkSize - 1) / chunkSize)
 
+	// 避免覆盖内容不同的同名文件，纳入存储时会在索引锁内再检查一次
+	overwrite := c.PostForm("overwrite") == "true"
+	if err := checkFileConflict(fileName, fileHash, hashAlgo, overwrite); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 秒传：相同内容已存在于存储中时直接建立索引，跳过所有分块
+	// 内容寻址存储以MD5为键，只有MD5哈希值可以直接查找已有内容；秒传不占用磁盘空间，但同样计入配额
+	if fileHash != "" && hashAlgo == utils.HashMD5 {
+		// 检查配额和建立索引在同一把锁内完成，避免并发的秒传都通过检查后共同超出配额
+		// 加锁顺序为 models.UploadsMutex → models.FilesMutex
+		models.UploadsMutex.Lock()
+		var meta *models.FileMeta
+		var linked bool
+		err := checkQuota(owner, fileName, fileSize)
+		if err == nil {
+			meta, linked, err = linkExistingBlob(c.Request.Context(), fileName, fileHash, fileSize, owner, overwrite)
+		}
+		models.UploadsMutex.Unlock()
+		if errors.Is(err, errQuotaExceeded) {
+			c.JSON(quotaStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, errFileExists) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "查询已有文件失败: " + err.Error(),
+			})
+			return
+		}
+		if linked {
+			// 清理可能残留的同一文件的上传会话
+			models.UploadsMutex.Lock()
+			staleInfo, stale := models.Uploads[fileID]
+			models.UploadsMutex.Unlock()
+			if stale {
+				removeUploadChunks(staleInfo)
+				models.RemoveUploadInfo(fileID)
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"file_id":        fileID,
+				"total_chunks":   totalChunks,
+				"chunk_size":     chunkSize,
+				"completed":      totalChunks,
+				"missing_chunks": []int{},
+				"file_hash":      meta.Hash,
+				"hash_algo":      hashAlgo,
+				"resumed":        false,
+				"instant":        true,
+			})
+			return
+		}
+	}
+
 	// 创建上传信息
 	models.UploadsMutex.Lock()
 	defer models.UploadsMutex.Unlock()
 
 	// 检查是否已存在上传任务
 	if info, exists := models.Uploads[fileID]; exists {
+		info.Mu.Lock()
+		completed := models.CountCompletedChunks(info.Completed)
+		missing := info.MissingChunks()
+		info.Mu.Unlock()
+
+		// 续传时沿用原会话的分块大小，客户端需按返回的 chunk_size 切分
 		c.JSON(http.StatusOK, gin.H{
-			"file_id":      fileID,
-			"total_chunks": info.TotalChunks,
-			"chunk_size":   info.ChunkSize,
-			"completed":    models.CountCompletedChunks(info.Completed),
-			"file_hash":    info.FileHash,
-			"resumed":      true,
+			"file_id":        fileID,
+			"total_chunks":   info.TotalChunks,
+			"chunk_size":     info.ChunkSize,
+			"completed":      completed,
+			"missing_chunks": missing,
+			"file_hash":      info.FileHash,
+			"hash_algo":      info.HashAlgorithm(),
+			"merkle_root":    info.MerkleRoot,
+			"resumed":        true,
+			"instant":        false,
+		})
+		return
+	}
+
+	// 新的上传任务按声明的文件大小预留配额，检查与创建会话在同一把锁内完成
+	if err := checkQuota(owner, fileName, fileSize); err != nil {
+		c.JSON(quotaStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := checkDiskSpace(fileSize); err != nil {
+		c.JSON(quotaStatus(err), gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
 
 	// 创建新的上传任务
-	models.Uploads[fileID] = &models.UploadInfo{
+	uploadInfo := &models.UploadInfo{
 		FileID:      fileID,
 		FileName:    fileName,
 		TotalChunks: totalChunks,
@@ -145,15 +354,45 @@ func InitUpload(c *gin.Context) {
 		ChunkSize:   chunkSize,
 		Completed:   make([]bool, totalChunks),
 		FileHash:    fileHash,
+		HashAlgo:    hashAlgo,
+		MerkleRoot:  rootHash,
+		Owner:       owner,
+		Overwrite:   overwrite,
 		ChunkHashes: make(map[int]string),
+		CreatedAt:   time.Now(),
+		Positional:  relayConfig.Upload.Assembly == config.AssemblyPositional,
 	}
 
+	// 按偏移量写入时预先分配整个文件，磁盘空间不足时在初始化阶段即可发现
+	if uploadInfo.Positional {
+		if err := createPartFile(uploadInfo); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "创建上传文件失败: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	// 持久化上传会话，服务重启后可继续上传
+	if err := models.SaveUploadInfo(uploadInfo); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "保存上传会话失败: " + err.Error(),
+		})
+		return
+	}
+	models.Uploads[fileID] = uploadInfo
+
 	c.JSON(http.StatusOK, gin.H{
-		"file_id":      fileID,
-		"total_chunks": totalChunks,
-		"chunk_size":   chunkSize,
-		"file_hash":    fileHash,
-		"resumed":      false,
+		"file_id":        fileID,
+		"total_chunks":   totalChunks,
+		"chunk_size":     chunkSize,
+		"completed":      0,
+		"missing_chunks": uploadInfo.MissingChunks(),
+		"file_hash":      fileHash,
+		"hash_algo":      hashAlgo,
+		"merkle_root":    rootHash,
+		"resumed":        false,
+		"instant":        false,
 	})
 }
 
@@ -161,7 +400,8 @@ func InitUpload(c *gin.Context) {
 func UploadChunk(c *gin.Context) {
 	fileID := c.PostForm("file_id")
 	chunkIndexStr := c.PostForm("chunk_index")
-	chunkHash := c.PostForm("chunk_hash") // 接收分块哈希值
+	chunkHash := strings.ToLower(c.PostForm("chunk_hash")) // 接收分块哈希值，算法与初始化上传时声明的一致
+	proof := parseMerkleProof(c.PostForm("merkle_proof"))  // 分块的 Merkle 证明，初始化时提供了 merkle_root 时必填
 
 	if fileID == "" || chunkIndexStr == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -182,6 +422,14 @@ func UploadChunk(c *gin.Context) {
 		return
 	}
 
+	// tus 会话按偏移量连续写入，不能混用分块接口
+	if uploadInfo.Protocol == models.ProtocolTus {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "该会话由 tus 协议创建，请使用 tus 接口上传",
+		})
+		return
+	}
+
 	chunkIndex, err := strconv.Atoi(chunkIndexStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -199,9 +447,20 @@ func UploadChunk(c *gin.Context) {
 		return
 	}
 
-	// 检查分块是否已上传
+	// 同一分块的并发请求依次处理，写入和标记完成不会交错
+	unlock := uploadInfo.LockChunk(chunkIndex)
+	defer unlock()
+
+	// 检查分块是否已上传，overwrite=true 时重新上传已完成的分块（如完成上传时完整性验证失败）
 	uploadInfo.Mu.Lock()
-	if uploadInfo.Completed[chunkIndex] {
+	if uploadInfo.Completing {
+		uploadInfo.Mu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "该上传正在合并，暂时不能上传分块",
+		})
+		return
+	}
+	if uploadInfo.Completed[chunkIndex] && c.PostForm("overwrite") != "true" {
 		uploadInfo.Mu.Unlock()
 		c.JSON(http.StatusOK, gin.H{
 			"message":     "分块已上传",
@@ -220,62 +479,133 @@ func UploadChunk(c *gin.Context) {
 		return
 	}
 
-	// 创建临时文件路径
-	chunkPath := filepath.Join(config.TempDir, fmt.Sprintf("%s-%d", fileID, chunkIndex))
-
-	// 保存分块文件
-	if err := c.SaveUploadedFile(file, chunkPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "保存分块文件失败: " + err.Error(),
+	// 配额按声明的文件大小预留，分块不能超出其应有的大小
+	if file.Size > uploadInfo.ChunkLength(chunkIndex) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":       fmt.Sprintf("%s: 最大 %d 字节，实际 %d 字节", errChunkSizeMismatch, uploadInfo.ChunkLength(chunkIndex), file.Size),
+			"chunk_index": chunkIndex,
+		})
+		return
+	}
+	if err := checkSessionQuota(uploadInfo, file.Size); err != nil {
+		c.JSON(quotaStatus(err), gin.H{
+			"error":       err.Error(),
+			"chunk_index": chunkIndex,
 		})
 		return
 	}
 
-	// 如果提供了分块哈希值，验证分块完整性
-	if chunkHash != "" {
-		// 计算保存的分块文件哈希值 - 使用MD5而非SHA256
-		calculatedHash, err := utils.CalculateChunkMD5(chunkPath)
-		if err != nil {
-			os.Remove(chunkPath) // 删除有问题的文件
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "计算分块哈希值失败: " + err.Error(),
-			})
-			return
+	verify := func(hash string) error {
+		// 如果提供了分块哈希值，验证分块完整性，不匹配的分块不会被标记为已完成
+		if chunkHash != "" && hash != chunkHash {
+			return errChunkHashMismatch
 		}
-
-		// 比较哈希值
-		if calculatedHash != chunkHash {
-			os.Remove(chunkPath) // 删除不匹配的文件
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":           "分块完整性验证失败",
-				"expected_hash":   chunkHash,
-				"calculated_hash": calculatedHash,
-				"chunk_index":     chunkIndex,
-			})
-			return
+		// 通过 Merkle 证明确认分块属于客户端声明的文件
+		if uploadInfo.MerkleRoot != "" {
+			return utils.VerifyMerkleProof(uploadInfo.HashAlgorithm(), hash, chunkIndex, uploadInfo.TotalChunks, proof, uploadInfo.MerkleRoot)
 		}
+		return nil
+	}
 
-		// 保存分块哈希值
-		uploadInfo.Mu.Lock()
-		uploadInfo.ChunkHashes[chunkIndex] = calculatedHash
-		uploadInfo.Mu.Unlock()
+	// 保存分块，写入的同时按会话声明的算法计算哈希值
+	var calculatedHash string
+	if uploadInfo.Positional {
+		calculatedHash, err = writeChunkAt(file, uploadInfo, chunkIndex, uploadInfo.HashAlgorithm(), verify)
+	} else {
+		chunkPath := filepath.Join(relayConfig.Storage.TempDir, models.ChunkFileName(fileID, chunkIndex))
+		calculatedHash, err = saveChunkFile(file, chunkPath, uploadInfo.HashAlgorithm(), verify)
+	}
+	if errors.Is(err, errChunkSizeMismatch) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":       err.Error(),
+			"chunk_index": chunkIndex,
+		})
+		return
+	}
+	if errors.Is(err, utils.ErrInvalidMerkleProof) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":           "分块的 Merkle 证明校验失败",
+			"merkle_root":     uploadInfo.MerkleRoot,
+			"calculated_hash": calculatedHash,
+			"chunk_index":     chunkIndex,
+		})
+		return
+	}
+	if errors.Is(err, errChunkHashMismatch) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":           "分块完整性验证失败",
+			"expected_hash":   chunkHash,
+			"calculated_hash": calculatedHash,
+			"hash_algo":       uploadInfo.HashAlgorithm(),
+			"chunk_index":     chunkIndex,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "保存分块文件失败: " + err.Error(),
+		})
+		return
 	}
 
-	// 更新分块状态
+	// 更新分块状态，并保存分块哈希值
 	uploadInfo.Mu.Lock()
+	uploadInfo.ChunkHashes[chunkIndex] = calculatedHash
 	uploadInfo.Completed[chunkIndex] = true
 	completed := models.CountCompletedChunks(uploadInfo.Completed)
 	uploadInfo.Mu.Unlock()
 
+	// 记录分块进度，失败时仅记录日志：重启后会根据磁盘上的分块文件重新核对
+	if err := models.SaveUploadInfo(uploadInfo); err != nil {
+		fmt.Printf("保存上传会话 %s 失败: %v\n", fileID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":     "分块上传成功",
 		"chunk_index": chunkIndex,
 		"completed":   completed,
 		"total":       uploadInfo.TotalChunks,
-		"verified":    chunkHash != "",
+		"verified":    chunkHash != "" || uploadInfo.MerkleRoot != "",
 	})
 }
 
+// errChunkHashMismatch 分块哈希值与客户端提供的不一致
+var errChunkHashMismatch = errors.New("分块完整性验证失败")
+
+// errChunkSizeMismatch 分块大小与会话的分块大小不一致
+var errChunkSizeMismatch = errors.New("分块大小不正确")
+
+// saveChunkFile 将上传的分块流式写入临时文件，写入的同时按 algo 计算哈希值，避免写入后再读取一遍
+// verify 通过后才将临时文件重命名为分块文件，校验失败或出错时删除临时文件
+func saveChunkFile(file *multipart.FileHeader, chunkPath, algo string, verify func(hash string) error) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(chunkPath), filepath.Base(chunkPath)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(dst.Name())
+
+	hasher := utils.NewHash(algo)
+	if _, err := io.Copy(dst, io.TeeReader(src, hasher)); err != nil {
+		dst.Close()
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if err := verify(hash); err != nil {
+		return hash, err
+	}
+	return hash, os.Rename(dst.Name(), chunkPath)
+}
+
 // CompleteUpload 完成上传，合并文件
 func CompleteUpload(c *gin.Context) {
 	fileID := c.PostForm("file_id")
@@ -299,8 +629,22 @@ func CompleteUpload(c *gin.Context) {
 		return
 	}
 
-	// 检查所有分块是否已上传
+	if uploadInfo.Protocol == models.ProtocolTus {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "该会话由 tus 协议创建，数据接收完毕后会自动完成",
+		})
+		return
+	}
+
+	// 检查所有分块是否已上传，并标记会话正在合并，拒绝并发的完成请求
 	uploadInfo.Mu.Lock()
+	if uploadInfo.Completing {
+		uploadInfo.Mu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "该上传正在合并，请勿重复提交",
+		})
+		return
+	}
 	for i, completed := range uploadInfo.Completed {
 		if !completed {
 			uploadInfo.Mu.Unlock()
@@ -311,105 +655,223 @@ func CompleteUpload(c *gin.Context) {
 			return
 		}
 	}
+	uploadInfo.Completing = true
 	uploadInfo.Mu.Unlock()
 
-	// 合并文件
-	finalPath := filepath.Join(config.UploadsDir, uploadInfo.FileName)
-	finalFile, err := os.Create(finalPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "创建最终文件失败: " + err.Error(),
-		})
-		return
-	}
-	defer finalFile.Close()
+	defer func() {
+		uploadInfo.Mu.Lock()
+		uploadInfo.Completing = false
+		uploadInfo.Mu.Unlock()
+	}()
 
-	// 逐个合并分块
-	for i := 0; i < uploadInfo.TotalChunks; i++ {
-		chunkPath := filepath.Join(config.TempDir, fmt.Sprintf("%s-%d", fileID, i))
-		chunkFile, err := os.Open(chunkPath)
+	// 提供了 Merkle 根哈希时，确认全部分块哈希值构成的树与之一致
+	if uploadInfo.MerkleRoot != "" {
+		calculatedRoot, err := uploadMerkleRoot(uploadInfo)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("打开分块文件失败: %s", err.Error()),
+				"error": "计算 Merkle 根哈希失败: " + err.Error(),
 			})
 			return
 		}
-
-		_, err = io.Copy(finalFile, chunkFile)
-		chunkFile.Close()
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("合并分块文件失败: %s", err.Error()),
+		if calculatedRoot != uploadInfo.MerkleRoot {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":           "Merkle 根哈希不一致",
+				"expected_root":   uploadInfo.MerkleRoot,
+				"calculated_root": calculatedRoot,
 			})
 			return
 		}
+	}
 
-		// 删除临时分块文件
-		os.Remove(chunkPath)
+	// 合并到存储的暂存区，合并的同时计算文件哈希值，作为内容寻址存储的键
+	// 暂存对象写入完毕并落盘后才会纳入存储，下载方不会看到不完整的文件
+	ctx := c.Request.Context()
+	staged, err := mergeUploadChunks(ctx, uploadInfo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
 	// 校验合并后的文件完整性（如果初始化时提供了文件哈希）
+	// 校验失败时丢弃暂存对象并保留分块和会话，客户端可用 overwrite=true 重新上传有问题的分块后再次完成
 	fileIntegrityVerified := false
-	var calculatedFileHash string
-
 	if uploadInfo.FileHash != "" {
-		// 计算合并后的文件哈希值 - 使用MD5而非SHA256
-		calculatedFileHash, err = utils.CalculateFileMD5(finalPath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "计算文件哈希值失败: " + err.Error(),
-				"note":  "文件已合并，但未能验证完整性",
-			})
-			return
-		}
+		if staged.digest != uploadInfo.FileHash {
+			fileStorage.Delete(ctx, staged.key)
+
+			uploadInfo.Mu.Lock()
+			chunkHashes := make(map[int]string, len(uploadInfo.ChunkHashes))
+			for i, chunkHash := range uploadInfo.ChunkHashes {
+				chunkHashes[i] = chunkHash
+			}
+			uploadInfo.Mu.Unlock()
 
-		// 比较哈希值
-		if calculatedFileHash != uploadInfo.FileHash {
-			// 哈希不匹配，但文件已合并，通知客户端验证失败
-			c.JSON(http.StatusOK, gin.H{
-				"message":          "文件已合并，但完整性验证失败",
-				"file_name":        uploadInfo.FileName,
-				"file_size":        uploadInfo.TotalSize,
-				"file_path":        finalPath,
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":            "文件完整性验证失败，分块已保留",
 				"expected_hash":    uploadInfo.FileHash,
-				"calculated_hash":  calculatedFileHash,
+				"calculated_hash":  staged.digest,
+				"hash_algo":        uploadInfo.HashAlgorithm(),
+				"chunk_hashes":     chunkHashes,
 				"integrity_status": "failed",
 			})
-
-			// 清理上传信息
-			models.UploadsMutex.Lock()
-			delete(models.Uploads, fileID)
-			models.UploadsMutex.Unlock()
-
 			return
 		}
-
 		fileIntegrityVerified = true
 	}
 
+	// 纳入内容寻址存储，相同内容只保存一份；失败时保留分块，客户端可以重试
+	// 上传期间其他上传写入了内容不同的同名文件时无法通过重试解决，丢弃会话
+	meta, deduplicated, err := commitObject(ctx, staged, uploadInfo.FileName, uploadInfo.Owner, uploadInfo.Overwrite)
+	if errors.Is(err, errFileExists) {
+		removeUploadChunks(uploadInfo)
+		models.RemoveUploadInfo(fileID)
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "保存文件失败: " + err.Error(),
+		})
+		return
+	}
+	finalPath := blobKey(meta.Hash)
+
+	// 文件已纳入存储，删除分块
+	removeUploadChunks(uploadInfo)
+
 	// 清理上传信息
-	models.UploadsMutex.Lock()
-	delete(models.Uploads, fileID)
-	models.UploadsMutex.Unlock()
+	models.RemoveUploadInfo(fileID)
 
 	// 构建响应
 	response := gin.H{
-		"message":   "文件上传完成",
-		"file_name": uploadInfo.FileName,
-		"file_size": uploadInfo.TotalSize,
-		"file_path": finalPath,
+		"message":      "文件上传完成",
+		"file_name":    uploadInfo.FileName,
+		"file_size":    uploadInfo.TotalSize,
+		"file_path":    finalPath,
+		"file_hash":    staged.hash,
+		"deduplicated": deduplicated,
+	}
+
+	// 客户端使用其他算法时同时返回该算法下的文件哈希值
+	if algo := uploadInfo.HashAlgorithm(); algo != utils.HashMD5 {
+		response["hash_algo"] = algo
+		response["file_digest"] = staged.digest
 	}
 
 	// 如果进行了完整性校验，添加相关信息
 	if uploadInfo.FileHash != "" {
 		response["integrity_verified"] = fileIntegrityVerified
-		response["file_hash"] = calculatedFileHash
+	}
+	if uploadInfo.MerkleRoot != "" {
+		response["merkle_root"] = uploadInfo.MerkleRoot
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// AbortUpload 取消上传，删除上传会话及已上传的分块
+func AbortUpload(c *gin.Context) {
+	fileID := c.Param("file_id")
+
+	models.UploadsMutex.Lock()
+	uploadInfo, exists := models.Uploads[fileID]
+	models.UploadsMutex.Unlock()
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "无效的 file_id",
+		})
+		return
+	}
+
+	reclaimed, err := discardUpload(uploadInfo)
+	if errors.Is(err, errUploadBusy) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "删除上传会话失败: " + err.Error(),
+		})
+		return
+	}
+	recordReclaimed(janitorRun{UploadsRemoved: 1, BytesReclaimed: reclaimed})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "上传已取消",
+		"file_id":         fileID,
+		"bytes_reclaimed": reclaimed,
+	})
+}
+
+// uploadMerkleRoot 计算上传会话全部分块哈希值构成的 Merkle 树的根哈希
+// 分块已写入但哈希值未能保存时（如保存会话前服务中断），读取分块文件重新计算
+func uploadMerkleRoot(info *models.UploadInfo) (string, error) {
+	info.Mu.Lock()
+	chunkHashes := make(map[int]string, len(info.ChunkHashes))
+	for i, chunkHash := range info.ChunkHashes {
+		chunkHashes[i] = chunkHash
+	}
+	info.Mu.Unlock()
+
+	algo := info.HashAlgorithm()
+	for i := 0; i < info.TotalChunks; i++ {
+		if _, ok := chunkHashes[i]; ok {
+			continue
+		}
+		var chunkHash string
+		var err error
+		if info.Positional {
+			chunkHash, err = chunkSectionHash(info, i, algo)
+		} else {
+			chunkHash, err = utils.CalculateFileHash(filepath.Join(relayConfig.Storage.TempDir, models.ChunkFileName(info.FileID, i)), algo)
+		}
+		if err != nil {
+			return "", err
+		}
+		chunkHashes[i] = chunkHash
+	}
+
+	tree, err := buildMerkleTree(algo, chunkHashes, info.TotalChunks)
+	if err != nil {
+		return "", err
+	}
+	return tree.Root(), nil
+}
+
+// mergeUploadChunks 按顺序将上传会话的所有分块合并写入存储的暂存区，合并的同时计算文件哈希值
+// 分块不会被删除，暂存对象由调用方校验后纳入存储或丢弃，成功纳入存储后再删除分块
+// 按偏移量写入的会话无需合并，直接导入预分配文件
+func mergeUploadChunks(ctx context.Context, info *models.UploadInfo) (*stagedObject, error) {
+	if info.Positional {
+		return stagePartFile(ctx, info)
+	}
+
+	chunkPaths := make([]string, info.TotalChunks)
+	readers := make([]io.Reader, 0, info.TotalChunks)
+	for i := range chunkPaths {
+		chunkPaths[i] = filepath.Join(relayConfig.Storage.TempDir, models.ChunkFileName(info.FileID, i))
+		chunkFile, err := os.Open(chunkPaths[i])
+		if err != nil {
+			return nil, fmt.Errorf("打开分块文件失败: %w", err)
+		}
+		defer chunkFile.Close()
+		readers = append(readers, chunkFile)
+	}
+
+	staged, err := stageObject(ctx, info.FileID, io.MultiReader(readers...), info.TotalSize, info.HashAlgorithm())
+	if err != nil {
+		return nil, fmt.Errorf("合并分块文件失败: %w", err)
+	}
+	return staged, nil
+}
+
 // CheckUploadStatus 查询上传状态
 func CheckUploadStatus(c *gin.Context) {
 	fileID := c.Query("file_id")
@@ -436,18 +898,22 @@ func CheckUploadStatus(c *gin.Context) {
 	// 计算已完成的分块
 	uploadInfo.Mu.Lock()
 	completedChunks := models.CountCompletedChunks(uploadInfo.Completed)
+	missingChunks := uploadInfo.MissingChunks()
 	uploadInfo.Mu.Unlock()
 
 	c.JSON(http.StatusOK, gin.H{
-		"file_id":      fileID,
-		"file_name":    uploadInfo.FileName,
-		"total_chunks": uploadInfo.TotalChunks,
-		"completed":    completedChunks,
-		"percentage":   float64(completedChunks) / float64(uploadInfo.TotalChunks) * 100,
+		"file_id":        fileID,
+		"file_name":      uploadInfo.FileName,
+		"total_chunks":   uploadInfo.TotalChunks,
+		"completed":      completedChunks,
+		"missing_chunks": missingChunks,
+		"percentage":     float64(completedChunks) / float64(uploadInfo.TotalChunks) * 100,
 	})
 }
 
 // SimpleDownload 简单文件下载处理
+// 支持 Range（含多区间）、If-Range、If-None-Match、If-Modified-Since 等标准请求头，
+// 以内容的MD5哈希值作为强 ETag，未纳入索引的旧文件同样如此
 func SimpleDownload(c *gin.Context) {
 	fileName := c.Query("file_name")
 	if fileName == "" {
@@ -457,16 +923,31 @@ func SimpleDownload(c *gin.Context) {
 		return
 	}
 
-	// 构建文件路径
-	filePath := filepath.Join(config.UploadsDir, fileName)
+	fileName, ok := bindFileName(c, fileName)
+	if !ok {
+		return
+	}
+
+	// 解析文件在存储中的对象键
+	fileKey, fileMeta, err := resolveFile(fileName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询文件索引失败: " + err.Error(),
+		})
+		return
+	}
 
 	// 检查文件是否存在
-	fileInfo, err := os.Stat(filePath)
+	fileInfo, err := fileStorage.Stat(c.Request.Context(), fileKey)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, storage.ErrNotExist) {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error": "找不到指定文件",
 			})
+		} else if errors.Is(err, storage.ErrInvalidKey) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "非法的文件路径",
+			})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "获取文件信息失败: " + err.Error(),
@@ -475,27 +956,15 @@ func SimpleDownload(c *gin.Context) {
 		return
 	}
 
-	// 如果文件比较小，直接下载
-	if fileInfo.Size() <= 10<<20 { // 10 MiB
-		c.File(filePath)
-		return
-	}
-
-	// 对于大文件，建议使用分块下载
-	fileID := utils.GenerateFileID(fileName, fileInfo.Size())
-	c.JSON(http.StatusOK, gin.H{
-		"message":           "文件过大，建议使用分块下载接口",
-		"file_id":           fileID,
-		"file_name":         fileName,
-		"file_size":         fileInfo.Size(),
-		"download_init_url": fmt.Sprintf("/file/download/init?file_name=%s", fileName),
-	})
+	// 任意大小的文件都直接发送，支持 Range 断点续传和条件请求
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(fileName)}))
+	serveFile(c, fileInfo, fileName, fileMeta)
 }
 
 // InitDownload 初始化大文件下载
 func InitDownload(c *gin.Context) {
 	fileName := c.Query("file_name")
-	chunkSizeStr := c.DefaultQuery("chunk_size", "1048576") // 默认1MB块大小
+	chunkSizeStr := c.DefaultQuery("chunk_size", strconv.FormatInt(relayConfig.Download.DefaultChunkSize, 10))
 
 	if fileName == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -504,25 +973,42 @@ func InitDownload(c *gin.Context) {
 		return
 	}
 
+	fileName, ok := bindFileName(c, fileName)
+	if !ok {
+		return
+	}
+
 	// 解析分块大小
 	chunkSize, err := strconv.ParseInt(chunkSizeStr, 10, 64)
-	if err != nil {
+	if err != nil || chunkSize <= 0 || chunkSize > relayConfig.Download.MaxChunkSize {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "chunk_size 参数格式不正确",
+			"error":          "chunk_size 参数格式不正确",
+			"max_chunk_size": relayConfig.Download.MaxChunkSize,
 		})
 		return
 	}
 
-	// 构建文件路径
-	filePath := filepath.Join(config.UploadsDir, fileName)
+	// 文件和分块哈希值使用的哈希算法
+	hashAlgo, err := utils.ParseHashAlgo(c.Query("hash_algo"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
-	// 检查文件是否存在
-	fileInfo, err := os.Stat(filePath)
+	// 解析文件并登记下载会话，会话结束前文件被覆盖或删除时原内容仍然保留
+	ctx := c.Request.Context()
+	downloadInfo, fileMeta, fileInfo, err := openDownload(ctx, fileName, chunkSize, hashAlgo)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, storage.ErrNotExist) {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error": "找不到指定文件",
 			})
+		} else if errors.Is(err, storage.ErrInvalidKey) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "非法的文件路径",
+			})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "获取文件信息失败: " + err.Error(),
@@ -530,44 +1016,46 @@ func InitDownload(c *gin.Context) {
 		}
 		return
 	}
-
-	// 计算文件总块数
-	fileSize := fileInfo.Size()
-	totalChunks := int((fileSize + chunkSize - 1) / chunkSize)
-
-	// 生成文件唯一标识
-	fileID := utils.GenerateFileID(fileName, fileSize)
-
-	// 计算文件哈希值 - 使用MD5而非SHA256
-	fileHash, err := utils.CalculateFileMD5(filePath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "计算文件哈希值失败: " + err.Error(),
-		})
-		return
-	}
-
-	// 预计算所有分块的哈希值
-	chunkHashes := make(map[int]string)
-
-	// 保存下载信息
-	downloadInfo := &models.DownloadInfo{
-		FileID:      fileID,
-		FileName:    fileName,
-		FilePath:    filePath,
-		TotalSize:   fileSize,
-		ChunkSize:   chunkSize,
-		TotalChunks: totalChunks,
-		CreatedAt:   time.Now(),
-		FileHash:    fileHash,
-		ChunkHashes: chunkHashes,
+	fileID := downloadInfo.FileID
+	fileSize := downloadInfo.TotalSize
+	totalChunks := downloadInfo.TotalChunks
+
+	// 分块哈希值来自持久化的哈希清单，清单只在文件写入后计算一次
+	// 内容寻址存储中的文件MD5哈希值已知，清单未就绪时在后台计算；
+	// 旧文件或使用其他算法时需要先读取一遍得到文件哈希值
+	if fileMeta != nil && hashAlgo == utils.HashMD5 {
+		downloadInfo.Mu.Lock()
+		downloadInfo.FileHash = fileMeta.Hash
+		downloadInfo.Mu.Unlock()
+		src := blobManifestSource(fileMeta.Hash, fileSize, hashAlgo)
+		if manifest, ok := cachedManifest(src, chunkSize); ok {
+			chunkHashes, _ := manifest.ChunkHashMap(chunkSize)
+			downloadInfo.Mu.Lock()
+			setDownloadHashes(downloadInfo, chunkHashes)
+			downloadInfo.Mu.Unlock()
+		} else {
+			go fillDownloadHashes(downloadInfo, src)
+		}
+	} else {
+		src := legacyManifestSource(fileName, fileSize, fileInfo.ModTime, hashAlgo)
+		if fileMeta != nil {
+			src = blobManifestSource(fileMeta.Hash, fileSize, hashAlgo)
+		}
+		manifest, err := loadManifest(ctx, src, chunkSize)
+		if err != nil {
+			releaseDownloads(ctx, models.RemoveDownloadInfo(fileID))
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "计算文件哈希值失败: " + err.Error(),
+			})
+			return
+		}
+		chunkHashes, _ := manifest.ChunkHashMap(chunkSize)
+		downloadInfo.Mu.Lock()
+		downloadInfo.FileHash = manifest.FileHash
+		setDownloadHashes(downloadInfo, chunkHashes)
+		downloadInfo.Mu.Unlock()
 	}
 
-	models.SaveDownloadInfo(downloadInfo)
-
-	// 启动后台协程计算每个分块的哈希值
-	go calculateChunkHashes(downloadInfo)
-
 	// 返回下载初始化信息
 	c.JSON(http.StatusOK, gin.H{
 		"file_id":      fileID,
@@ -575,46 +1063,61 @@ func InitDownload(c *gin.Context) {
 		"file_size":    fileSize,
 		"chunk_size":   chunkSize,
 		"total_chunks": totalChunks,
-		"file_hash":    fileHash,
+		"file_hash":    downloadInfo.FileHash,
+		"hash_algo":    hashAlgo,
+		"merkle_root":  merkleRoot(downloadInfo),
 		"download_url": fmt.Sprintf("/file/download/chunk?file_id=%s&chunk_index=", fileID),
 	})
 }
 
-// calculateChunkHashes 计算文件所有分块的哈希值（作为后台任务运行）
-func calculateChunkHashes(info *models.DownloadInfo) {
-	file, err := os.Open(info.FilePath)
+// openDownload 解析文件并登记下载会话
+// 解析与登记在索引锁内完成，之后文件即使被覆盖或删除，会话引用的 blob 也会保留到会话结束
+func openDownload(ctx context.Context, fileName string, chunkSize int64, hashAlgo string) (*models.DownloadInfo, *models.FileMeta, *storage.ObjectInfo, error) {
+	models.FilesMutex.Lock()
+	defer models.FilesMutex.Unlock()
+
+	fileKey, fileMeta, err := resolveFile(fileName)
 	if err != nil {
-		fmt.Printf("打开文件失败: %s\n", err.Error())
-		return
+		return nil, nil, nil, err
+	}
+	fileInfo, err := fileStorage.Stat(ctx, fileKey)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	defer file.Close()
-
-	buffer := make([]byte, info.ChunkSize)
-
-	for i := 0; i < info.TotalChunks; i++ {
-		// 定位到对应块的位置
-		offset := int64(i) * info.ChunkSize
-		_, err := file.Seek(offset, io.SeekStart)
-		if err != nil {
-			fmt.Printf("定位到分块位置失败: %s\n", err.Error())
-			continue
-		}
-
-		// 读取块数据
-		n, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
-			fmt.Printf("读取分块数据失败: %s\n", err.Error())
-			continue
-		}
 
-		// 计算哈希值 - 使用MD5而非SHA256
-		hash := utils.CalculateDataMD5(buffer[:n])
+	info := &models.DownloadInfo{
+		FileID:      utils.GenerateFileID(fileName, fileInfo.Size),
+		FileName:    fileName,
+		FileKey:     fileKey,
+		TotalSize:   fileInfo.Size,
+		ChunkSize:   chunkSize,
+		TotalChunks: int((fileInfo.Size + chunkSize - 1) / chunkSize),
+		CreatedAt:   time.Now(),
+		HashAlgo:    hashAlgo,
+		ChunkHashes: make(map[int]string),
+	}
+	if fileMeta != nil {
+		info.BlobHash = fileMeta.Hash
+	}
+	// 空文件没有分块可以下载，不登记会话，否则会话只能等待过期
+	if info.TotalChunks > 0 {
+		models.SaveDownloadInfo(info)
+	}
+	return info, fileMeta, fileInfo, nil
+}
 
-		// 保存分块哈希值
-		info.Mu.Lock()
-		info.ChunkHashes[i] = hash
-		info.Mu.Unlock()
+// fillDownloadHashes 等待哈希清单计算完成后填充下载会话的分块哈希值（作为后台任务运行）
+func fillDownloadHashes(info *models.DownloadInfo, src manifestSource) {
+	manifest, err := loadManifest(context.Background(), src, info.ChunkSize)
+	if err != nil {
+		fmt.Printf("计算文件 %s 的哈希清单失败: %s\n", info.FileName, err.Error())
+		return
 	}
+
+	chunkHashes, _ := manifest.ChunkHashMap(info.ChunkSize)
+	info.Mu.Lock()
+	setDownloadHashes(info, chunkHashes)
+	info.Mu.Unlock()
 }
 
 // DownloadChunk 下载文件分块
@@ -663,19 +1166,21 @@ func DownloadChunk(c *gin.Context) {
 		end = downloadInfo.TotalSize
 	}
 
-	// 打开文件
-	file, err := os.Open(downloadInfo.FilePath)
+	// 从存储中读取分块对应的区间
+	chunkReader, err := fileStorage.Get(c.Request.Context(), downloadInfo.FileKey, start, end-start)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "打开文件失败: " + err.Error(),
 		})
 		return
 	}
-	defer file.Close()
+	defer chunkReader.Close()
 
-	// 获取分块哈希值（如果已计算）
+	// 获取分块哈希值（如果已计算），并记录访问时间，长时间未被访问的会话会被后台清理
 	downloadInfo.Mu.Lock()
 	chunkHash, hashExists := downloadInfo.ChunkHashes[chunkIndex]
+	tree := downloadInfo.Merkle
+	downloadInfo.AccessedAt = time.Now()
 	downloadInfo.Mu.Unlock()
 
 	// 设置响应头
@@ -685,34 +1190,48 @@ func DownloadChunk(c *gin.Context) {
 	c.Header("Accept-Ranges", "bytes")
 	c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, downloadInfo.TotalSize))
 
-	// 如果存在分块哈希值，添加到响应头
+	// 如果存在分块哈希值，连同算法名称添加到响应头
 	if hashExists {
 		c.Header("X-Chunk-Hash", chunkHash)
+		c.Header("X-Chunk-Hash-Algo", downloadInfo.HashAlgo)
 	}
 
-	// 设置状态码为206（部分内容）
-	c.Status(http.StatusPartialContent)
-
-	// 定位到文件的指定位置
-	_, err = file.Seek(start, io.SeekStart)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "文件定位失败: " + err.Error(),
-		})
-		return
+	// 请求证明时返回分块的 Merkle 证明，客户端可用 InitDownload 返回的根哈希单独校验该分块
+	if c.Query("proof") == "true" && tree != nil {
+		proof, _ := tree.Proof(chunkIndex)
+		c.Header("X-Merkle-Root", tree.Root())
+		c.Header("X-Merkle-Proof", strings.Join(proof, ","))
 	}
 
-	// 创建一个限制读取大小的Reader
-	limitReader := io.LimitReader(file, end-start)
+	// 设置状态码为206（部分内容）
+	c.Status(http.StatusPartialContent)
 
 	// 将数据直接写入响应
-	_, err = io.Copy(c.Writer, limitReader)
+	_, err = io.Copy(c.Writer, chunkReader)
 	if err != nil {
 		// 这里不需要返回错误，因为响应已经开始写入
 		fmt.Printf("发送文件块失败: %s\n", err.Error())
 	}
 }
 
+// ReleaseDownload 结束下载会话，客户端校验完全部分块后调用
+// 会话存在期间文件不能被删除或移动，客户端未调用时由后台清理在 download_ttl 无活动后结束
+func ReleaseDownload(c *gin.Context) {
+	info := models.RemoveDownloadInfo(c.Param("file_id"))
+	if info == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "找不到下载信息",
+		})
+		return
+	}
+	releaseDownloads(c.Request.Context(), info)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "下载会话已结束",
+		"file_id": info.FileID,
+	})
+}
+
 // GetDownloadInfo 获取下载信息
 func GetDownloadInfo(c *gin.Context) {
 	fileID := c.Query("file_id")
@@ -746,6 +1265,8 @@ func GetDownloadInfo(c *gin.Context) {
 		"total_chunks":          downloadInfo.TotalChunks,
 		"created_at":            downloadInfo.CreatedAt,
 		"file_hash":             downloadInfo.FileHash,
+		"hash_algo":             downloadInfo.HashAlgo,
+		"merkle_root":           merkleRoot(downloadInfo),
 		"hash_completed_chunks": hashCompletedChunks,
 		"hash_progress":         float64(hashCompletedChunks) / float64(downloadInfo.TotalChunks) * 100,
 		"download_url":          fmt.Sprintf("/file/download/chunk?file_id=%s&chunk_index=", fileID),
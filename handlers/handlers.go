@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"com.example/relay/config"
+	"com.example/relay/storage"
+)
+
+// relayConfig 当前生效的中继配置，由 main 在启动时通过 Init 注入
+var relayConfig = config.Default()
+
+// fileStorage 文件存储后端，由 main 在启动时通过 Init 注入
+var fileStorage storage.Storage
+
+// Init 注入中继配置和存储后端，需在注册路由之前调用
+func Init(cfg *config.Config, st storage.Storage) {
+	relayConfig = cfg
+	fileStorage = st
+}
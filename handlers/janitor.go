@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// errUploadBusy 上传会话正在合并或清理，暂时不能删除
+var errUploadBusy = errors.New("该上传正在合并，暂时不能取消")
+
+// janitorRun 一次清理的结果
+type janitorRun struct {
+	StartedAt        time.Time `json:"started_at"`        // 开始时间
+	DurationMs       int64     `json:"duration_ms"`       // 耗时（毫秒）
+	UploadsRemoved   int       `json:"uploads_removed"`   // 清理的上传会话数
+	DownloadsRemoved int       `json:"downloads_removed"` // 清理的下载会话数
+	OrphansRemoved   int       `json:"orphans_removed"`   // 清理的孤立临时文件和暂存对象数
+	OutboxExpired    int       `json:"outbox_expired"`    // 清理的过期待投递消息数
+	BytesReclaimed   int64     `json:"bytes_reclaimed"`   // 回收的空间（字节）
+}
+
+// janitorStats 启动以来的清理统计
+type janitorStats struct {
+	Runs             int64       `json:"runs"`              // 清理次数
+	UploadsRemoved   int64       `json:"uploads_removed"`   // 清理的上传会话数，包括客户端主动取消的
+	DownloadsRemoved int64       `json:"downloads_removed"` // 清理的下载会话数
+	OrphansRemoved   int64       `json:"orphans_removed"`   // 清理的孤立临时文件和暂存对象数
+	OutboxExpired    int64       `json:"outbox_expired"`    // 清理的过期待投递消息数
+	BytesReclaimed   int64       `json:"bytes_reclaimed"`   // 回收的空间（字节）
+	LastRun          *janitorRun `json:"last_run"`          // 最近一次清理的结果
+}
+
+var (
+	janitorTotals janitorStats
+	janitorMutex  sync.Mutex
+)
+
+// SetupJanitorRoutes 设置后台清理相关路由
+func SetupJanitorRoutes(router *gin.RouterGroup) {
+	router.GET("/stats", JanitorStats)
+}
+
+// JanitorStats 查询后台清理的统计信息
+func JanitorStats(c *gin.Context) {
+	janitorMutex.Lock()
+	stats := janitorTotals
+	janitorMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"interval":     relayConfig.Janitor.Interval.String(),
+		"upload_ttl":   relayConfig.Janitor.UploadTTL.String(),
+		"download_ttl": relayConfig.Janitor.DownloadTTL.String(),
+		"orphan_ttl":   relayConfig.Janitor.OrphanTTL.String(),
+		"stats":        stats,
+	})
+}
+
+// StartJanitor 启动后台清理任务，每隔 janitor.interval 清理一次过期会话和孤立临时文件
+// 间隔为 0 时不启动
+func StartJanitor() {
+	interval := relayConfig.Janitor.Interval
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runJanitor(context.Background())
+		}
+	}()
+}
+
+// runJanitor 执行一次清理并累计统计信息
+func runJanitor(ctx context.Context) janitorRun {
+	run := janitorRun{StartedAt: time.Now()}
+
+	// tus 会话有自己的过期时间，不受 upload_ttl 是否为 0 影响
+	cleanupExpiredUploads(&run)
+	if ttl := relayConfig.Janitor.DownloadTTL; ttl > 0 {
+		expired := models.CleanupExpiredDownloads(ttl)
+		releaseDownloads(ctx, expired...)
+		run.DownloadsRemoved = len(expired)
+	}
+	if ttl := relayConfig.Janitor.OrphanTTL; ttl > 0 {
+		cleanupOrphanTempFiles(ttl, &run)
+		cleanupOrphanStaging(ctx, ttl, &run)
+		cleanupStorageTempFiles(ctx, ttl, &run)
+	}
+	if expired, err := models.CleanupExpiredOutbox(relayConfig.Outbox.StatusRetention); err != nil {
+		fmt.Printf("清理过期的待投递消息失败: %v\n", err)
+	} else {
+		run.OutboxExpired = expired
+	}
+	run.DurationMs = time.Since(run.StartedAt).Milliseconds()
+
+	janitorMutex.Lock()
+	janitorTotals.Runs++
+	janitorTotals.LastRun = &run
+	janitorMutex.Unlock()
+	recordReclaimed(run)
+
+	if run.UploadsRemoved+run.DownloadsRemoved+run.OrphansRemoved+run.OutboxExpired > 0 {
+		fmt.Printf("后台清理：上传会话 %d 个，下载会话 %d 个，孤立文件 %d 个，过期消息 %d 条，回收 %d 字节\n",
+			run.UploadsRemoved, run.DownloadsRemoved, run.OrphansRemoved, run.OutboxExpired, run.BytesReclaimed)
+	}
+	return run
+}
+
+// cleanupExpiredUploads 清理过期的上传会话及其分块
+// 分块上传会话超过 janitor.upload_ttl 无活动即过期，tus 会话以其 Upload-Expires 为准
+func cleanupExpiredUploads(run *janitorRun) {
+	models.UploadsMutex.Lock()
+	infos := make([]*models.UploadInfo, 0, len(models.Uploads))
+	for _, info := range models.Uploads {
+		infos = append(infos, info)
+	}
+	models.UploadsMutex.Unlock()
+
+	now := time.Now()
+	for _, info := range infos {
+		if !uploadExpired(info, now) {
+			continue
+		}
+		reclaimed, err := discardUpload(info)
+		if err != nil {
+			if !errors.Is(err, errUploadBusy) {
+				fmt.Printf("清理过期上传会话 %s 失败: %v\n", info.FileID, err)
+			}
+			continue
+		}
+		run.UploadsRemoved++
+		run.BytesReclaimed += reclaimed
+	}
+}
+
+// uploadExpired 上传会话是否已过期
+func uploadExpired(info *models.UploadInfo, now time.Time) bool {
+	info.Mu.Lock()
+	defer info.Mu.Unlock()
+
+	if info.Protocol == models.ProtocolTus {
+		return !info.ExpiresAt.IsZero() && now.After(info.ExpiresAt)
+	}
+
+	ttl := relayConfig.Janitor.UploadTTL
+	lastActive := info.UpdatedAt
+	if lastActive.IsZero() {
+		lastActive = info.CreatedAt
+	}
+	return ttl > 0 && now.Sub(lastActive) > ttl
+}
+
+// discardUpload 删除上传会话及其分块，返回回收的空间；会话正在合并时返回 errUploadBusy
+func discardUpload(info *models.UploadInfo) (int64, error) {
+	info.Mu.Lock()
+	if info.Completing {
+		info.Mu.Unlock()
+		return 0, errUploadBusy
+	}
+	// 借用合并标记阻止删除过程中开始合并，会话删除后该标记不再有意义
+	info.Completing = true
+	info.Mu.Unlock()
+
+	info.WriteMu.Lock()
+	defer info.WriteMu.Unlock()
+
+	reclaimed := uploadDiskUsage(info)
+	removeUploadChunks(info)
+	return reclaimed, models.RemoveUploadInfo(info.FileID)
+}
+
+// recordReclaimed 累计清理统计
+func recordReclaimed(run janitorRun) {
+	janitorMutex.Lock()
+	defer janitorMutex.Unlock()
+
+	janitorTotals.UploadsRemoved += int64(run.UploadsRemoved)
+	janitorTotals.DownloadsRemoved += int64(run.DownloadsRemoved)
+	janitorTotals.OrphansRemoved += int64(run.OrphansRemoved)
+	janitorTotals.OutboxExpired += int64(run.OutboxExpired)
+	janitorTotals.BytesReclaimed += run.BytesReclaimed
+}
+
+// uploadDiskUsage 上传会话在临时目录中占用的空间
+func uploadDiskUsage(info *models.UploadInfo) int64 {
+	if info.Positional {
+		if stat, err := os.Stat(partFilePath(info)); err == nil {
+			return stat.Size()
+		}
+		return 0
+	}
+
+	var usage int64
+	for i := 0; i < info.TotalChunks; i++ {
+		if stat, err := os.Stat(filepath.Join(relayConfig.Storage.TempDir, models.ChunkFileName(info.FileID, i))); err == nil {
+			usage += stat.Size()
+		}
+	}
+	return usage
+}
+
+// cleanupOrphanTempFiles 清理临时目录中超过 ttl 未修改、且不属于任何上传会话的文件
+// 写入分块时使用的 .tmp- 临时文件只会因进程中断而残留，超过 ttl 即删除
+func cleanupOrphanTempFiles(ttl time.Duration, run *janitorRun) {
+	entries, err := os.ReadDir(relayConfig.Storage.TempDir)
+	if err != nil {
+		fmt.Printf("读取临时目录失败: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil || now.Sub(stat.ModTime()) <= ttl {
+			continue
+		}
+
+		name := entry.Name()
+		if !strings.Contains(name, ".tmp-") && uploadExists(tempFileOwner(name)) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(relayConfig.Storage.TempDir, name)); err != nil {
+			fmt.Printf("删除孤立临时文件 %s 失败: %v\n", name, err)
+			continue
+		}
+		run.OrphansRemoved++
+		run.BytesReclaimed += stat.Size()
+	}
+}
+
+// tempFileOwner 临时文件所属的上传会话标识：分块文件为 <file_id>-<index>，预分配文件为 <file_id>.part
+func tempFileOwner(name string) string {
+	if i := strings.IndexAny(name, "-."); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// uploadExists 上传会话是否存在
+func uploadExists(fileID string) bool {
+	models.UploadsMutex.Lock()
+	defer models.UploadsMutex.Unlock()
+
+	_, exists := models.Uploads[fileID]
+	return exists
+}
+
+// cleanupOrphanStaging 清理存储暂存区中超过 ttl 的对象，它们只会因进程在纳入存储前中断而残留
+// 按偏移量写入的会话以硬链接导入暂存区，对象的修改时间可能较早，会话存在时跳过
+func cleanupOrphanStaging(ctx context.Context, ttl time.Duration, run *janitorRun) {
+	objects, err := fileStorage.List(ctx, stagingDir+"/")
+	if err != nil {
+		fmt.Printf("列出暂存对象失败: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, object := range objects {
+		if now.Sub(object.ModTime) <= ttl || uploadExists(path.Base(object.Key)) {
+			continue
+		}
+		if err := fileStorage.Delete(ctx, object.Key); err != nil {
+			fmt.Printf("删除孤立暂存对象 %s 失败: %v\n", object.Key, err)
+			continue
+		}
+		run.OrphansRemoved++
+		run.BytesReclaimed += object.Size
+	}
+}
+
+// cleanupStorageTempFiles 清理暂存区和 blob 目录中超过 ttl 的临时文件
+// 本地存储写入对象时先写入同目录下的 .tmp- 临时文件，List 会跳过它们，只能单独清理
+func cleanupStorageTempFiles(ctx context.Context, ttl time.Duration, run *janitorRun) {
+	sweeper, ok := fileStorage.(storage.TempSweeper)
+	if !ok {
+		return
+	}
+
+	before := time.Now().Add(-ttl)
+	sweep := func(dir string) {
+		removed, err := sweeper.SweepTemp(ctx, dir+"/", before)
+		if err != nil {
+			fmt.Printf("清理 %s 中的临时文件失败: %v\n", dir, err)
+		}
+		for _, object := range removed {
+			run.OrphansRemoved++
+			run.BytesReclaimed += object.Size
+		}
+	}
+
+	sweep(stagingDir)
+	// 拼接 blob 时使用的临时硬链接沿用源文件的修改时间，blob 的写入都在索引锁内进行，清理时同样持有该锁
+	models.FilesMutex.Lock()
+	sweep(blobsDir)
+	models.FilesMutex.Unlock()
+}
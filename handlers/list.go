@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/storage"
+	"com.example/relay/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 文件列表分页参数
+const (
+	defaultListPageSize = 50
+	maxListPageSize     = 1000
+)
+
+// fileEntry 文件列表中的一项
+type fileEntry struct {
+	Name       string    `json:"name"`                // 文件名（相对存储根目录的路径）
+	Size       int64     `json:"size"`                // 文件大小
+	Hash       string    `json:"hash,omitempty"`      // 内容哈希，未纳入索引的旧文件为空
+	MimeType   string    `json:"mime_type,omitempty"` // MIME 类型
+	Owner      string    `json:"owner,omitempty"`     // 上传者（节点ID或客户端标识）
+	CreatedAt  time.Time `json:"created_at"`          // 创建时间
+	ModifiedAt time.Time `json:"modified_at"`         // 最近一次修改时间
+
+	key string // 存储对象键
+}
+
+// fileEntryLess 文件列表的排序方式
+var fileEntryLess = map[string]func(a, b *fileEntry) bool{
+	"name": func(a, b *fileEntry) bool { return a.Name < b.Name },
+	"size": func(a, b *fileEntry) bool { return a.Size < b.Size },
+	"created_at": func(a, b *fileEntry) bool {
+		return a.CreatedAt.Before(b.CreatedAt)
+	},
+	"modified_at": func(a, b *fileEntry) bool {
+		return a.ModifiedAt.Before(b.ModifiedAt)
+	},
+}
+
+// ListFiles 列出文件索引中的文件和尚未纳入索引的旧文件及其元信息
+// 查询参数：
+//   - dir：目录，默认为存储根目录；recursive=false 时只列出该目录下的直接文件，并返回其子目录
+//   - prefix：文件名前缀（相对存储根目录）
+//   - sort：排序字段 name/size/created_at/modified_at，order：asc/desc
+//   - page、page_size：分页，page 从 1 开始
+func ListFiles(c *gin.Context) {
+	sortBy := c.DefaultQuery("sort", "name")
+	less, ok := fileEntryLess[sortBy]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "sort 参数只能是 name、size、created_at 或 modified_at",
+		})
+		return
+	}
+
+	order := c.DefaultQuery("order", "asc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "order 参数只能是 asc 或 desc",
+		})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "page 参数格式不正确",
+		})
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultListPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxListPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":         "page_size 参数格式不正确",
+			"max_page_size": maxListPageSize,
+		})
+		return
+	}
+
+	recursive := c.DefaultQuery("recursive", "true") != "false"
+	prefix := c.Query("prefix")
+
+	// 目录需要是合法的相对路径，转换为以 / 结尾的前缀
+	dir := c.Query("dir")
+	dirPrefix := ""
+	if dir != "" {
+		dir, err = utils.CleanFilePath(dir)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		dirPrefix = dir + "/"
+	}
+
+	// 以 dir 和 prefix 中较长的一个作为扫描前缀，两者不相容时结果必然为空
+	scanPrefix := dirPrefix
+	if strings.HasPrefix(prefix, dirPrefix) {
+		scanPrefix = prefix
+	} else if !strings.HasPrefix(dirPrefix, prefix) {
+		c.JSON(http.StatusOK, gin.H{
+			"files":     []*fileEntry{},
+			"total":     0,
+			"page":      page,
+			"page_size": pageSize,
+		})
+		return
+	}
+
+	entries, err := collectFileEntries(c.Request.Context(), scanPrefix)
+	if errors.Is(err, storage.ErrInvalidKey) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "非法的文件路径",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "获取文件列表失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 非递归时子目录下的文件归并为目录名
+	files := entries[:0]
+	dirSet := map[string]bool{}
+	for _, entry := range entries {
+		rest := strings.TrimPrefix(entry.Name, dirPrefix)
+		if !recursive {
+			if i := strings.Index(rest, "/"); i >= 0 {
+				dirSet[dirPrefix+rest[:i]] = true
+				continue
+			}
+		}
+		files = append(files, entry)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if order == "desc" {
+			a, b = b, a
+		}
+		if less(a, b) != less(b, a) {
+			return less(a, b)
+		}
+		return a.Name < b.Name
+	})
+
+	total := len(files)
+	start := (page - 1) * pageSize
+	if start > total {
+		start = total
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+	files = files[start:end]
+
+	// 只为当前页中尚未记录类型的文件识别 MIME 类型
+	for _, entry := range files {
+		if entry.MimeType == "" {
+			entry.MimeType = detectMimeType(c.Request.Context(), entry.key)
+		}
+		if entry.MimeType == "" {
+			entry.MimeType = mime.TypeByExtension(path.Ext(entry.Name))
+		}
+	}
+
+	response := gin.H{
+		"files":     files,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	}
+	if !recursive {
+		dirs := make([]string, 0, len(dirSet))
+		for d := range dirSet {
+			dirs = append(dirs, d)
+		}
+		sort.Strings(dirs)
+		response["dirs"] = dirs
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// collectFileEntries 收集名称以 prefix 开头的文件
+// 包括文件索引中的文件和引入内容寻址存储之前直接以文件名保存、尚未纳入索引的旧文件；
+// blob、暂存区、临时目录和 .tmp- 临时文件等存储内部使用的对象不会列出
+func collectFileEntries(ctx context.Context, prefix string) ([]*fileEntry, error) {
+	metas, err := models.ListFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*fileEntry, 0, len(metas))
+	indexed := make(map[string]bool, len(metas))
+	for _, meta := range metas {
+		indexed[meta.Name] = true
+		entries = append(entries, &fileEntry{
+			Name:       meta.Name,
+			Size:       meta.Size,
+			Hash:       meta.Hash,
+			MimeType:   meta.MimeType,
+			Owner:      meta.Owner,
+			CreatedAt:  meta.CreatedAt,
+			ModifiedAt: meta.ModifiedAt,
+			key:        blobKey(meta.Hash),
+		})
+	}
+
+	objects, err := fileStorage.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		if indexed[object.Key] {
+			continue
+		}
+		if name, err := cleanFileName(object.Key); err != nil || name != object.Key {
+			continue
+		}
+		entries = append(entries, &fileEntry{
+			Name:       object.Key,
+			Size:       object.Size,
+			CreatedAt:  object.ModTime,
+			ModifiedAt: object.ModTime,
+			key:        object.Key,
+		})
+	}
+	return entries, nil
+}
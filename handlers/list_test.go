@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestListFilesIncludesLegacyFiles(t *testing.T) {
+	cfg := newTestEnv(t)
+	putTestFile(t, "docs/indexed.txt", "indexed", "n1")
+
+	// 引入内容寻址存储之前直接以文件名保存的旧文件，以及存储内部使用的对象
+	root := cfg.Storage.UploadsDir
+	for _, key := range []string{
+		"docs/legacy.txt",
+		"legacy-root.txt",
+		"staging/upload-1",
+		"temp/abc-0",
+		"docs/.tmp-123",
+		"blobs/ab/abcdef",
+	} {
+		p := filepath.Join(root, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("legacy"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/file/list", ListFiles)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+		dirs  []string
+	}{
+		{"全部文件", "", []string{"docs/indexed.txt", "docs/legacy.txt", "legacy-root.txt"}, nil},
+		{"目录", "?dir=docs", []string{"docs/indexed.txt", "docs/legacy.txt"}, nil},
+		{"前缀", "?prefix=docs/leg", []string{"docs/legacy.txt"}, nil},
+		{"非递归", "?recursive=false", []string{"legacy-root.txt"}, []string{"docs"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file/list"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("状态码 = %d，响应 %s", w.Code, w.Body.String())
+			}
+
+			var resp struct {
+				Files []struct {
+					Name string `json:"name"`
+					Hash string `json:"hash"`
+				} `json:"files"`
+				Dirs []string `json:"dirs"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, f := range resp.Files {
+				names = append(names, f.Name)
+				if indexed := f.Name == "docs/indexed.txt"; indexed != (f.Hash != "") {
+					t.Errorf("%s 的哈希值 = %q", f.Name, f.Hash)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Fatalf("文件 = %v，期望 %v", names, tt.want)
+			}
+			if tt.dirs != nil && !reflect.DeepEqual(resp.Dirs, tt.dirs) {
+				t.Fatalf("目录 = %v，期望 %v", resp.Dirs, tt.dirs)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"com.example/relay/models"
+	"com.example/relay/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// checkFileInUse 检查文件是否被下载会话或同步任务引用，调用方需持有 models.FilesMutex，
+// 并在释放之前完成删除或移动，期间不会有新的下载会话引用该文件
+// 被引用且未指定 force=true 时返回 409；指定 force 时结束这些下载会话和同步任务
+func checkFileInUse(c *gin.Context, name string) bool {
+	downloads := models.FindDownloadsByFile(name)
+	job, syncing, err := models.GetSyncJob(name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询同步任务失败: " + err.Error(),
+		})
+		return false
+	}
+	if len(downloads) == 0 && !syncing {
+		return true
+	}
+
+	if c.Query("force") != "true" && c.PostForm("force") != "true" {
+		response := gin.H{
+			"error":     "文件正在被下载或同步，请稍后重试，或设置 force=true 强制操作",
+			"file_name": name,
+			"downloads": downloads,
+		}
+		if syncing {
+			response["sync"] = job
+		}
+		c.JSON(http.StatusConflict, response)
+		return false
+	}
+
+	for _, id := range downloads {
+		releaseDownloadBlobs(c.Request.Context(), models.RemoveDownloadInfo(id))
+	}
+	if syncing {
+		if err := models.RemoveSyncJob(name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "删除同步任务失败: " + err.Error(),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteFile 删除文件
+// 文件名中的 / 需编码为 %2F，内容不再被其他文件引用时才从存储中删除
+func DeleteFile(c *gin.Context) {
+	fileName, ok := bindFileName(c, c.Param("name"))
+	if !ok {
+		return
+	}
+
+	models.FilesMutex.Lock()
+	if !checkFileInUse(c, fileName) {
+		models.FilesMutex.Unlock()
+		return
+	}
+	removed, err := removeFile(c.Request.Context(), fileName)
+	models.FilesMutex.Unlock()
+
+	if errors.Is(err, storage.ErrInvalidKey) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "非法的文件路径",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "删除文件失败: " + err.Error(),
+		})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "找不到指定文件",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "文件已删除",
+		"file_name": fileName,
+	})
+}
+
+// RenameFile 重命名文件，new_name 为新的文件名，不能包含目录
+func RenameFile(c *gin.Context) {
+	newName := c.PostForm("new_name")
+	if newName == "" || strings.ContainsAny(newName, "/\\") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "new_name 不能为空且不能包含目录",
+		})
+		return
+	}
+
+	relocateFile(c, func(fileName string) string {
+		return path.Join(path.Dir(fileName), newName)
+	})
+}
+
+// MoveFile 将文件移动到 dest_dir 目录下，文件名不变；dest_dir 为空表示存储根目录
+func MoveFile(c *gin.Context) {
+	destDir := c.PostForm("dest_dir")
+	relocateFile(c, func(fileName string) string {
+		return path.Join(destDir, path.Base(fileName))
+	})
+}
+
+// relocateFile 重命名和移动的公共流程，target 根据原文件名计算目标文件名
+func relocateFile(c *gin.Context, target func(fileName string) string) {
+	fileName := c.PostForm("file_name")
+	if fileName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "参数不完整，请提供文件名",
+		})
+		return
+	}
+
+	fileName, ok := bindFileName(c, fileName)
+	if !ok {
+		return
+	}
+	newName, ok := bindFileName(c, target(fileName))
+	if !ok {
+		return
+	}
+	if newName == fileName {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "目标文件名与原文件名相同",
+		})
+		return
+	}
+
+	models.FilesMutex.Lock()
+	if !checkFileInUse(c, fileName) {
+		models.FilesMutex.Unlock()
+		return
+	}
+	found, err := renameFile(c.Request.Context(), fileName, newName, c.PostForm("overwrite") == "true")
+	models.FilesMutex.Unlock()
+
+	switch {
+	case errors.Is(err, errFileExists):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, storage.ErrInvalidKey):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "非法的文件路径",
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "移动文件失败: " + err.Error(),
+		})
+	case !found:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "找不到指定文件",
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "文件已移动",
+			"file_name": fileName,
+			"new_name":  newName,
+		})
+	}
+}
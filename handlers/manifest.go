@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/utils"
+)
+
+// manifestSource 计算哈希清单所需的文件信息
+type manifestSource struct {
+	key       string    // 清单键
+	algo      string    // 哈希算法
+	objectKey string    // 存储对象键
+	size      int64     // 文件大小
+	modTime   time.Time // 旧文件的修改时间，内容寻址存储中的文件内容不可变，为零值
+}
+
+// blobManifestSource 内容寻址存储中的文件在指定哈希算法下的清单来源，hash 为文件的MD5哈希值
+func blobManifestSource(hash string, size int64, algo string) manifestSource {
+	return manifestSource{key: models.BlobManifestKey(hash, algo), algo: algo, objectKey: blobKey(hash), size: size}
+}
+
+// legacyManifestSource 未纳入索引的旧文件在指定哈希算法下的清单来源
+func legacyManifestSource(name string, size int64, modTime time.Time, algo string) manifestSource {
+	return manifestSource{key: models.LegacyManifestKey(name, algo), algo: algo, objectKey: name, size: size, modTime: modTime}
+}
+
+// matches 清单是否与文件当前的内容一致
+func (s manifestSource) matches(manifest *models.HashManifest) bool {
+	return manifest.Size == s.size && (s.modTime.IsZero() || manifest.ModTime.Equal(s.modTime))
+}
+
+// hasChunkSize 清单中是否包含指定分块大小的哈希值，chunkSize 为 0 表示只需要整个文件的哈希值
+func hasChunkSize(manifest *models.HashManifest, chunkSize int64) bool {
+	if chunkSize == 0 {
+		return true
+	}
+	_, ok := manifest.ChunkHashes[chunkSize]
+	return ok
+}
+
+// manifestBuild 正在计算的哈希清单，同一文件的并发请求共享一次计算
+type manifestBuild struct {
+	done     chan struct{}
+	manifest *models.HashManifest
+	err      error
+}
+
+var (
+	manifestBuilds      = make(map[string]*manifestBuild)
+	manifestBuildsMutex sync.Mutex
+)
+
+// cachedManifest 获取已保存且仍然有效的哈希清单，不会触发计算
+func cachedManifest(src manifestSource, chunkSize int64) (*models.HashManifest, bool) {
+	manifest, exists, err := models.GetManifest(src.key)
+	if err != nil {
+		fmt.Printf("读取哈希清单 %s 失败: %v\n", src.key, err)
+		return nil, false
+	}
+	if !exists || !src.matches(manifest) || !hasChunkSize(manifest, chunkSize) {
+		return nil, false
+	}
+	return manifest, true
+}
+
+// loadManifest 获取包含指定分块大小的哈希清单，清单不存在或已失效时读取文件计算一次并保存
+// 同一文件同时只会有一个计算任务，其余请求等待其结果
+func loadManifest(ctx context.Context, src manifestSource, chunkSize int64) (*models.HashManifest, error) {
+	for {
+		manifest, exists, err := models.GetManifest(src.key)
+		if err != nil {
+			return nil, err
+		}
+		valid := exists && src.matches(manifest)
+		if valid && hasChunkSize(manifest, chunkSize) {
+			return manifest, nil
+		}
+
+		manifestBuildsMutex.Lock()
+		build, running := manifestBuilds[src.key]
+		if !running {
+			build = &manifestBuild{done: make(chan struct{})}
+			manifestBuilds[src.key] = build
+		}
+		manifestBuildsMutex.Unlock()
+
+		if running {
+			select {
+			case <-build.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if build.err != nil {
+				return nil, build.err
+			}
+			if hasChunkSize(build.manifest, chunkSize) {
+				return build.manifest, nil
+			}
+			// 刚完成的计算不包含所需的分块大小，重新检查
+			continue
+		}
+
+		var previous *models.HashManifest
+		if valid {
+			previous = manifest
+		}
+		build.manifest, build.err = buildManifest(src, chunkSize, previous)
+
+		manifestBuildsMutex.Lock()
+		delete(manifestBuilds, src.key)
+		manifestBuildsMutex.Unlock()
+		close(build.done)
+
+		return build.manifest, build.err
+	}
+}
+
+// buildManifest 顺序读取一遍文件，同时计算整个文件和所有分块大小下每个分块的哈希值
+// 计算的分块大小包括配置的分块大小、本次请求的分块大小以及原清单中已有的分块大小
+func buildManifest(src manifestSource, chunkSize int64, previous *models.HashManifest) (*models.HashManifest, error) {
+	sizes := append([]int64{}, relayConfig.Download.ManifestChunkSizes...)
+	if chunkSize > 0 {
+		sizes = append(sizes, chunkSize)
+	}
+	if previous != nil {
+		for size := range previous.ChunkHashes {
+			sizes = append(sizes, size)
+		}
+	}
+
+	// 计算在后台进行，不随触发计算的请求结束而取消
+	reader, err := fileStorage.Get(context.Background(), src.objectKey, 0, -1)
+	if err != nil {
+		return nil, fmt.Errorf("打开文件失败: %w", err)
+	}
+	defer reader.Close()
+
+	fileHasher := utils.NewHash(src.algo)
+	recorder := newManifestRecorder(sizes, src.algo)
+	buf := make([]byte, 4*1024*1024)
+	if _, err := io.CopyBuffer(io.MultiWriter(fileHasher, recorder), reader, buf); err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	manifest := recorder.manifest(src, hex.EncodeToString(fileHasher.Sum(nil)))
+
+	// 保存失败不影响本次使用，下次请求时会重新计算
+	if err := models.SaveManifest(manifest); err != nil {
+		fmt.Printf("保存哈希清单 %s 失败: %v\n", src.key, err)
+	}
+	return manifest, nil
+}
+
+// manifestRecorder 在数据写入过程中按同一哈希算法计算配置的各分块大小下的分块哈希值
+type manifestRecorder struct {
+	algo    string
+	hashers []*chunkHasher
+}
+
+// newManifestRecorder 创建计算指定分块大小的哈希清单记录器，重复的分块大小只计算一次
+func newManifestRecorder(sizes []int64, algo string) *manifestRecorder {
+	r := &manifestRecorder{algo: algo}
+	seen := make(map[int64]bool, len(sizes))
+	for _, size := range sizes {
+		if !seen[size] {
+			seen[size] = true
+			r.hashers = append(r.hashers, &chunkHasher{size: size, hash: utils.NewHash(algo)})
+		}
+	}
+	return r
+}
+
+// Write 写入数据
+func (r *manifestRecorder) Write(p []byte) (int, error) {
+	for _, h := range r.hashers {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// manifest 数据写入完毕后生成哈希清单，fileHash 为整个文件的哈希值
+func (r *manifestRecorder) manifest(src manifestSource, fileHash string) *models.HashManifest {
+	manifest := &models.HashManifest{
+		Key:         src.key,
+		Algo:        r.algo,
+		FileHash:    fileHash,
+		Size:        src.size,
+		ModTime:     src.modTime,
+		ChunkHashes: make(map[int64][]string, len(r.hashers)),
+		CreatedAt:   time.Now(),
+	}
+	for _, h := range r.hashers {
+		manifest.ChunkHashes[h.size] = h.sum()
+	}
+	return manifest
+}
+
+// chunkHasher 按固定分块大小计算每个分块的哈希值
+type chunkHasher struct {
+	size   int64     // 分块大小
+	filled int64     // 当前分块已写入的字节数
+	hash   hash.Hash // 当前分块的哈希
+	hashes []string  // 已完成分块的哈希值
+}
+
+// Write 写入数据，跨越分块边界时结束当前分块
+func (h *chunkHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := h.size - h.filled
+		if int64(len(p)) < take {
+			take = int64(len(p))
+		}
+		h.hash.Write(p[:take])
+		h.filled += take
+		p = p[take:]
+
+		if h.filled == h.size {
+			h.flush()
+		}
+	}
+	return n, nil
+}
+
+// flush 结束当前分块
+func (h *chunkHasher) flush() {
+	h.hashes = append(h.hashes, hex.EncodeToString(h.hash.Sum(nil)))
+	h.hash.Reset()
+	h.filled = 0
+}
+
+// sum 结束最后一个不完整的分块，返回所有分块的哈希值
+func (h *chunkHasher) sum() []string {
+	if h.filled > 0 {
+		h.flush()
+	}
+	if h.hashes == nil {
+		return []string{}
+	}
+	return h.hashes
+}
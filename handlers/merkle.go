@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"com.example/relay/models"
+	"com.example/relay/utils"
+)
+
+// buildMerkleTree 以全部分块的哈希值构建 Merkle 树，缺少任意分块的哈希值时返回错误
+func buildMerkleTree(algo string, chunkHashes map[int]string, totalChunks int) (*utils.MerkleTree, error) {
+	hashes := make([]string, totalChunks)
+	for i := range hashes {
+		chunkHash, ok := chunkHashes[i]
+		if !ok {
+			return nil, fmt.Errorf("缺少分块 %d 的哈希值", i)
+		}
+		hashes[i] = chunkHash
+	}
+	return utils.NewMerkleTree(algo, hashes)
+}
+
+// setDownloadHashes 设置下载会话的分块哈希值并构建 Merkle 树，调用方需持有 info.Mu
+func setDownloadHashes(info *models.DownloadInfo, chunkHashes map[int]string) {
+	info.ChunkHashes = chunkHashes
+	if info.TotalChunks == 0 {
+		return
+	}
+	tree, err := buildMerkleTree(info.HashAlgo, chunkHashes, info.TotalChunks)
+	if err != nil {
+		fmt.Printf("构建文件 %s 的 Merkle 树失败: %v\n", info.FileName, err)
+		return
+	}
+	info.Merkle = tree
+}
+
+// merkleRoot 返回下载会话的 Merkle 根哈希，分块哈希值尚未就绪时为空
+func merkleRoot(info *models.DownloadInfo) string {
+	info.Mu.Lock()
+	defer info.Mu.Unlock()
+
+	if info.Merkle == nil {
+		return ""
+	}
+	return info.Merkle.Root()
+}
+
+// parseMerkleRoot 校验客户端提供的根哈希，长度需与哈希算法的输出一致
+func parseMerkleRoot(root, algo string) (string, error) {
+	root = strings.ToLower(strings.TrimSpace(root))
+	digest, err := hex.DecodeString(root)
+	if err != nil || len(digest) != utils.NewHash(algo).Size() {
+		return "", fmt.Errorf("merkle_root 不是有效的 %s 哈希值", algo)
+	}
+	return root, nil
+}
+
+// parseMerkleProof 解析以逗号分隔的十六进制兄弟节点哈希值，自底向上排列
+func parseMerkleProof(proof string) []string {
+	nodes := []string{}
+	for _, node := range strings.Split(proof, ",") {
+		if node = strings.ToLower(strings.TrimSpace(node)); node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
@@ -1,6 +1,12 @@
 package handlers
 
 import (
+	"errors"
+	"net/http"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/utils"
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,20 +20,285 @@ func SetupNodeRoutes(router *gin.RouterGroup) {
 
 	// 初始化节点，由 manager 调用
 	router.POST("/init/:id", InitNodeById)
+
+	// 列出所有节点，由 manager 调用
+	router.GET("/list", ListNodes)
+
+	// 查询单个节点信息
+	router.GET("/info/:uid", GetNodeInfo)
+
+	// 查询节点的存储用量和配额，由 manager 调用
+	router.GET("/usage", ListNodeUsage)
+	router.GET("/usage/:uid", GetNodeUsage)
+
+	// 查询和管理节点离线期间的待投递消息，由 manager 调用
+	router.GET("/outbox", ListOutbox)
+	router.GET("/outbox/:uid", GetNodeOutbox)
+	router.DELETE("/outbox/:uid/:id", DeleteOutboxMessage)
+
+	// 查询消息的投递状态
+	router.GET("/message/:id", GetMessageStatus)
+
+	// 向节点发送 RPC 请求并等待响应，由 manager 调用
+	router.POST("/:uid/rpc", NodeRPC)
+}
+
+// RegisterNodeRequest 节点注册请求
+type RegisterNodeRequest struct {
+	UID          string            `json:"uid"`
+	Name         string            `json:"name" binding:"required"`
+	Version      string            `json:"version"`
+	Labels       map[string]string `json:"labels"`
+	Capabilities []string          `json:"capabilities"`
 }
 
 // RegisterNode 注册节点
+// 未提供 uid 时由服务端生成；首次注册会签发节点凭证（secret），用于后续上报和建立 WebSocket 连接
+// 重复注册需要携带凭证，会更新节点的描述信息，但保留生命周期状态
 func RegisterNode(c *gin.Context) {
-	c.String(200, "注册成功")
+	var req RegisterNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "参数不完整: " + err.Error(),
+		})
+		return
+	}
+
+	if req.UID == "" {
+		uid, err := utils.GenerateNodeID()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "生成节点ID失败: " + err.Error(),
+			})
+			return
+		}
+		req.UID = uid
+	}
+
+	models.NodesMutex.Lock()
+	defer models.NodesMutex.Unlock()
+
+	node, exists, err := models.GetNode(req.UID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询节点失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 已注册的节点更新信息时必须提供凭证，防止他人通过重复注册冒充节点
+	if exists {
+		if status, err := authenticateNode(c, req.UID); err != nil {
+			c.JSON(status, gin.H{
+				"error": "节点认证失败: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	now := time.Now()
+	if !exists {
+		node = &models.NodeInfo{
+			UID:          req.UID,
+			State:        models.NodeRegistered,
+			RegisteredAt: now,
+		}
+	}
+
+	node.Name = req.Name
+	node.Version = req.Version
+	node.Labels = req.Labels
+	node.Capabilities = req.Capabilities
+	node.LastSeenAt = now
+	node.UpdatedAt = now
+
+	response := gin.H{
+		"message":    "注册成功",
+		"node":       node,
+		"registered": !exists,
+	}
+
+	// 首次注册时签发节点凭证，仅返回这一次；节点信息和凭证一起写入
+	if !exists {
+		secret, err := models.CreateNode(node)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "保存节点失败: " + err.Error(),
+			})
+			return
+		}
+		response["secret"] = secret
+	} else if err := models.SaveNode(node); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "保存节点失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // ReportNodeState 报告节点状态
 func ReportNodeState(c *gin.Context) {
-	c.String(200, "连接成功")
+	uid := c.Query("uid")
+	if uid == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "缺少节点ID参数",
+		})
+		return
+	}
+
+	if status, err := authenticateNode(c, uid); err != nil {
+		c.JSON(status, gin.H{
+			"error": "节点认证失败: " + err.Error(),
+		})
+		return
+	}
+
+	models.NodesMutex.Lock()
+	defer models.NodesMutex.Unlock()
+
+	node, exists, err := models.GetNode(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询节点失败: " + err.Error(),
+		})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "节点未注册",
+		})
+		return
+	}
+
+	if status := c.Query("status"); status != "" {
+		node.Status = status
+	}
+	if version := c.Query("version"); version != "" {
+		node.Version = version
+	}
+	node.LastSeenAt = time.Now()
+
+	if err := models.SaveNode(node); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "保存节点失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "连接成功",
+		"node":    node,
+	})
 }
 
-// InitNode 初始化节点
+// InitNodeById 初始化节点
+// 将节点迁移到 initializing 状态并下发 init_node 指令，节点通过 WebSocket 回复结果
 func InitNodeById(c *gin.Context) {
 	id := c.Param("id")
-	c.String(200, "初始化成功，节点ID：%s", id)
+
+	if len(wsManager.GetNodeConnById(id)) == 0 {
+		_, exists, err := models.GetNode(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "查询节点失败: " + err.Error(),
+			})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "节点未注册",
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "节点不在线，无法初始化",
+		})
+		return
+	}
+
+	node, err := models.TransitionNode(id, models.NodeInitializing)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrNodeNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, models.ErrInvalidTransition) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 指令写入待投递队列，节点断线重连后仍会收到
+	msg, err := deliverMessage(id, TextMsg{Type: InitNode, Data: id}, 0, 0)
+	if err != nil {
+		models.TransitionNode(id, models.NodeInitFailed)
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrNodeNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"error": "下发初始化指令失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "初始化指令已下发",
+		"message_id": msg.ID,
+		"node":       node,
+	})
+}
+
+// ListNodes 列出所有已注册节点
+func ListNodes(c *gin.Context) {
+	nodes, err := models.ListNodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询节点列表失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 附带节点当前的在线状态
+	result := make([]gin.H, 0, len(nodes))
+	for _, node := range nodes {
+		result = append(result, gin.H{
+			"node":   node,
+			"online": len(wsManager.GetNodeConnById(node.UID)) > 0,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nodes": result,
+		"total": len(result),
+	})
+}
+
+// GetNodeInfo 查询单个节点信息
+func GetNodeInfo(c *gin.Context) {
+	uid := c.Param("uid")
+
+	node, exists, err := models.GetNode(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询节点失败: " + err.Error(),
+		})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "节点未注册",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"node":        node,
+		"online":      len(wsManager.GetNodeConnById(uid)) > 0,
+		"connections": len(wsManager.GetNodeConnById(uid)),
+	})
 }
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// outboxRetryTick 检查未确认消息是否需要重发的间隔
+const outboxRetryTick = time.Second
+
+// errDeliveryFailed 消息重试次数用尽仍未被确认
+var errDeliveryFailed = errors.New("消息重试次数用尽")
+
+// outboxReplaying 正在发送待投递消息的节点，由 models.OutboxMutex 保护
+var outboxReplaying = make(map[string]bool)
+
+// deliverMessage 向节点投递需要确认的消息：消息带上唯一标识，节点处理后以 ack 或 nack 回复
+// 消息先写入节点的待投递队列，节点在线时立即按优先级和写入顺序发送，离线时等节点重新连接后发送；
+// 发送后未确认的消息按退避间隔重发，节点需按消息标识去重。ttl 为 0 时使用 outbox.ttl；
+// 目标节点未注册时返回 models.ErrNodeNotFound，不会为其保留消息
+func deliverMessage(uid string, msg TextMsg, priority int, ttl time.Duration) (*models.OutboxMessage, error) {
+	if _, exists, err := models.GetNode(uid); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w: %s", models.ErrNodeNotFound, uid)
+	}
+
+	id, err := utils.GenerateMessageID()
+	if err != nil {
+		return nil, err
+	}
+	msg.ID = id
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = relayConfig.Outbox.TTL
+	}
+
+	now := time.Now()
+	record := &models.OutboxMessage{
+		ID:        id,
+		UID:       uid,
+		Priority:  priority,
+		Data:      data,
+		Status:    models.DeliveryPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	models.OutboxMutex.Lock()
+	defer models.OutboxMutex.Unlock()
+
+	dropped, err := models.SaveOutboxMessage(record, relayConfig.Outbox.MaxMessages)
+	for _, d := range dropped {
+		fmt.Printf("节点 %s 的待投递消息过多，丢弃消息 %s\n", uid, d.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wsManager.GetNodeConnById(uid)) > 0 {
+		startOutboxReplay(uid)
+	}
+	return record, nil
+}
+
+// retryBackoff 第 attempts 次发送后等待确认的时间，从 outbox.retry_interval 开始每次翻倍，不超过 outbox.max_retry_interval
+func retryBackoff(attempts int) time.Duration {
+	backoff := relayConfig.Outbox.RetryInterval
+	for i := 1; i < attempts && backoff < relayConfig.Outbox.MaxRetryInterval; i++ {
+		backoff *= 2
+	}
+	return min(backoff, relayConfig.Outbox.MaxRetryInterval)
+}
+
+// sendOutboxMessage 发送一次消息，记录发送次数和下一次重发的时间，调用方需持有 models.OutboxMutex
+// 发送次数已达 outbox.max_attempts 时将消息标记为失败并返回 errDeliveryFailed；节点离线时消息回到待发送状态并返回 errNodeOffline
+func sendOutboxMessage(msg *models.OutboxMessage) error {
+	if msg.Attempts >= relayConfig.Outbox.MaxAttempts {
+		reason := fmt.Sprintf("发送 %d 次后仍未收到确认", msg.Attempts)
+		if err := models.FinishOutboxMessage(msg, models.DeliveryFailed, reason); err != nil {
+			return err
+		}
+		fmt.Printf("向节点 %s 投递消息 %s 失败: %s\n", msg.UID, msg.ID, reason)
+		return errDeliveryFailed
+	}
+
+	// 部分连接发送失败时，消息已送达节点的其他连接，同样视为已发送
+	if err := wsManager.SendMessage(msg.UID, msg.Data); errors.Is(err, errNodeOffline) {
+		if msg.Status != models.DeliveryPending {
+			msg.Status = models.DeliveryPending
+			models.UpdateOutboxMessage(msg)
+		}
+		return err
+	}
+
+	now := time.Now()
+	msg.Status = models.DeliverySent
+	msg.Attempts++
+	msg.SentAt = now
+	msg.NextRetryAt = now.Add(retryBackoff(msg.Attempts))
+	return models.UpdateOutboxMessage(msg)
+}
+
+// replayOutboxOnConnect 节点上线时发送待投递消息
+// 上次连接期间已发送但未确认的消息很可能随连接一起丢失，重新连接后立即重发
+func replayOutboxOnConnect(uid string, online bool) {
+	if !online {
+		return
+	}
+	models.OutboxMutex.Lock()
+	defer models.OutboxMutex.Unlock()
+
+	pending, err := models.ListOutbox(uid)
+	if err != nil {
+		fmt.Printf("查询节点 %s 的待投递消息失败: %v\n", uid, err)
+		return
+	}
+	for _, msg := range pending {
+		if msg.Status == models.DeliverySent {
+			msg.Status = models.DeliveryPending
+			if err := models.UpdateOutboxMessage(msg); err != nil {
+				fmt.Printf("更新消息 %s 的投递状态失败: %v\n", msg.ID, err)
+			}
+		}
+	}
+	startOutboxReplay(uid)
+}
+
+// startOutboxReplay 在后台发送节点的待投递消息，同一节点同时只有一个发送协程，调用方需持有 models.OutboxMutex
+func startOutboxReplay(uid string) {
+	if outboxReplaying[uid] {
+		return
+	}
+	outboxReplaying[uid] = true
+	go replayOutbox(uid)
+}
+
+// replayOutbox 按顺序发送待投递消息，直到没有待发送的消息或节点再次离线
+// 每次只发送连接的待发送队列能容纳的消息数，避免积压的消息挤满队列导致连接被断开或消息被丢弃
+func replayOutbox(uid string) {
+	replayed := 0
+	for {
+		room, online := wsManager.waitSendRoom(uid)
+
+		models.OutboxMutex.Lock()
+		n, done, err := replayOutboxBatch(uid, room, online)
+		replayed += n
+		if done || err != nil {
+			delete(outboxReplaying, uid)
+			models.OutboxMutex.Unlock()
+			if err != nil {
+				fmt.Printf("向节点 %s 发送待投递消息失败: %v\n", uid, err)
+			}
+			if replayed > 1 {
+				fmt.Printf("已向节点 %s 发送 %d 条待投递消息\n", uid, replayed)
+			}
+			return
+		}
+		models.OutboxMutex.Unlock()
+	}
+}
+
+// replayOutboxBatch 发送至多 limit 条待发送的消息，已过期的消息标记为过期，调用方需持有 models.OutboxMutex
+// 返回发送的消息数，以及是否已无消息可发送（没有待发送的消息或节点离线）
+func replayOutboxBatch(uid string, limit int, online bool) (int, bool, error) {
+	pending, err := models.ListOutbox(uid)
+	if err != nil {
+		return 0, true, err
+	}
+
+	now := time.Now()
+	sent := 0
+	for _, msg := range pending {
+		if msg.Expired(now) {
+			if err := models.FinishOutboxMessage(msg, models.DeliveryExpired, "消息已过期"); err != nil {
+				return sent, true, err
+			}
+			continue
+		}
+		// 已发送、等待确认的消息由重发任务处理
+		if msg.Status != models.DeliveryPending {
+			continue
+		}
+		if !online {
+			return sent, true, nil
+		}
+		if sent >= limit {
+			return sent, false, nil
+		}
+
+		err := sendOutboxMessage(msg)
+		if errors.Is(err, errNodeOffline) {
+			return sent, true, nil
+		}
+		if errors.Is(err, errDeliveryFailed) {
+			continue
+		}
+		if err != nil {
+			return sent, true, err
+		}
+		sent++
+	}
+	return sent, true, nil
+}
+
+// StartOutboxRetry 启动重发任务，定期重发已发送但超时未确认的消息
+func StartOutboxRetry() {
+	go func() {
+		ticker := time.NewTicker(outboxRetryTick)
+		defer ticker.Stop()
+		for range ticker.C {
+			retryUnackedMessages()
+		}
+	}()
+}
+
+// retryUnackedMessages 重发到了重发时间仍未确认的消息；节点离线的消息等节点重新连接后再发送
+// 同时删除结束投递超过 outbox.status_retention 的记录
+func retryUnackedMessages() {
+	models.OutboxMutex.Lock()
+	defer models.OutboxMutex.Unlock()
+
+	if err := models.CleanupDeliveries(time.Now().Add(-relayConfig.Outbox.StatusRetention)); err != nil {
+		fmt.Printf("清理消息投递记录失败: %v\n", err)
+	}
+
+	due, err := models.ListDueOutbox(time.Now())
+	if err != nil {
+		fmt.Printf("查询未确认的消息失败: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, msg := range due {
+		if outboxReplaying[msg.UID] || len(wsManager.GetNodeConnById(msg.UID)) == 0 {
+			continue
+		}
+		if msg.Expired(now) {
+			if err := models.FinishOutboxMessage(msg, models.DeliveryExpired, "消息已过期"); err != nil {
+				fmt.Printf("更新消息 %s 的投递状态失败: %v\n", msg.ID, err)
+			}
+			continue
+		}
+		err := sendOutboxMessage(msg)
+		if err != nil && !errors.Is(err, errNodeOffline) && !errors.Is(err, errDeliveryFailed) {
+			fmt.Printf("重发消息 %s 失败: %v\n", msg.ID, err)
+		}
+	}
+}
+
+// finishDelivery 处理节点对消息的确认（ack）或拒绝（nack），节点只能确认发给自己的消息
+// 重发导致的重复确认会被忽略
+func finishDelivery(uid, id string, status models.DeliveryStatus, reason string) {
+	models.OutboxMutex.Lock()
+	defer models.OutboxMutex.Unlock()
+
+	msg, exists, err := models.GetNodeOutboxMessage(uid, id)
+	if err != nil {
+		fmt.Printf("查询消息 %s 失败: %v\n", id, err)
+		return
+	}
+	if !exists {
+		if _, done, _ := models.FindOutboxMessage(id); !done {
+			fmt.Printf("节点 %s 回复了未知的消息 %s\n", uid, id)
+		}
+		return
+	}
+
+	if err := models.FinishOutboxMessage(msg, status, reason); err != nil {
+		fmt.Printf("更新消息 %s 的投递状态失败: %v\n", id, err)
+	}
+	if status == models.DeliveryNacked {
+		fmt.Printf("节点 %s 拒绝了消息 %s: %s\n", uid, id, reason)
+	}
+}
+
+// ListOutbox 统计各节点尚未结束投递的消息数，由 manager 调用
+func ListOutbox(c *gin.Context) {
+	counts, err := models.CountOutbox()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询待投递消息失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nodes": counts,
+	})
+}
+
+// GetNodeOutbox 按投递顺序列出节点尚未结束投递的消息，由 manager 调用
+func GetNodeOutbox(c *gin.Context) {
+	uid := c.Param("uid")
+
+	messages, err := models.ListOutbox(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询待投递消息失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"uid":      uid,
+		"online":   len(wsManager.GetNodeConnById(uid)) > 0,
+		"messages": messages,
+		"total":    len(messages),
+	})
+}
+
+// DeleteOutboxMessage 删除节点的一条待投递消息，不再发送或重发，由 manager 调用
+func DeleteOutboxMessage(c *gin.Context) {
+	uid, id := c.Param("uid"), c.Param("id")
+
+	models.OutboxMutex.Lock()
+	msg, exists, err := models.GetNodeOutboxMessage(uid, id)
+	if err == nil && exists {
+		err = models.RemoveOutboxMessage(msg)
+	}
+	models.OutboxMutex.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "删除待投递消息失败: " + err.Error(),
+		})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "消息不存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "删除成功",
+		"id":      id,
+	})
+}
+
+// GetMessageStatus 查询消息的投递状态，发送方以投递时返回的 message_id 查询
+// 结束投递的消息保留 outbox.status_retention
+func GetMessageStatus(c *gin.Context) {
+	msg, exists, err := models.FindOutboxMessage(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询消息失败: " + err.Error(),
+		})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "消息不存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, msg)
+}
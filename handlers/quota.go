@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"com.example/relay/models"
+	"com.example/relay/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// errQuotaExceeded 写入后会超出节点或全局的存储配额
+	errQuotaExceeded = errors.New("超出存储配额")
+	// errDiskSpaceLow 写入后磁盘剩余空间会低于水位线
+	errDiskSpaceLow = errors.New("磁盘剩余空间不足")
+)
+
+// nodeUsage 节点的存储用量和配额
+type nodeUsage struct {
+	UID           string `json:"uid"`
+	Files         int    `json:"files"`          // 已保存的文件数
+	UsedBytes     int64  `json:"used_bytes"`     // 已保存的文件大小合计
+	ReservedBytes int64  `json:"reserved_bytes"` // 进行中的上传按声明的文件大小预留的空间
+	QuotaBytes    int64  `json:"quota_bytes"`    // 配额，0 表示不限制
+}
+
+// uploadReservations 进行中的上传会话按声明的文件大小预留的空间，返回各上传者的预留空间及合计
+// 已完成的会话的文件已计入用量，不再预留；调用方需持有 models.UploadsMutex
+func uploadReservations() (map[string]int64, int64) {
+	reserved := make(map[string]int64)
+	var total int64
+	for _, info := range models.Uploads {
+		info.Mu.Lock()
+		finished := info.Finished
+		info.Mu.Unlock()
+		if finished {
+			continue
+		}
+		reserved[info.Owner] += info.TotalSize
+		total += info.TotalSize
+	}
+	return reserved, total
+}
+
+// uploadOwner 确定上传者：uid 为客户端声明的节点ID，声明时需携带该节点的有效凭证，配额按该节点计算
+// 未声明节点时为匿名上传（如浏览器和 manager 的上传），只计入全局配额；失败时返回应使用的 HTTP 状态码
+func uploadOwner(c *gin.Context, uid string) (string, int, error) {
+	if uid == "" {
+		return "", http.StatusOK, nil
+	}
+	if status, err := authenticateNode(c, uid); err != nil {
+		return "", status, err
+	}
+	return uid, http.StatusOK, nil
+}
+
+// checkQuota 检查 owner 再写入 size 字节后是否会超出节点配额或全局配额，调用方需持有 models.UploadsMutex
+// 写入 name 会替换已有文件时，已有文件的大小不计入用量；进行中的上传会话已预留空间，校验其分块时 size 为 0
+// 匿名上传（owner 为空）只检查全局配额
+func checkQuota(owner, name string, size int64) error {
+	var nodeLimit int64
+	if owner != "" {
+		nodeLimit = relayConfig.Quota.NodeLimit(owner)
+	}
+	globalLimit := relayConfig.Quota.GlobalBytes
+	if nodeLimit == 0 && globalLimit == 0 {
+		return nil
+	}
+
+	reserved, totalReserved := uploadReservations()
+	nodeUsed := models.OwnerUsage(owner).Bytes + reserved[owner] + size
+	globalUsed := models.TotalUsage().Bytes + totalReserved + size
+
+	meta, exists, err := models.GetFile(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		globalUsed -= meta.Size
+		if meta.Owner == owner {
+			nodeUsed -= meta.Size
+		}
+	}
+
+	if nodeLimit > 0 && nodeUsed > nodeLimit {
+		return fmt.Errorf("%w: 节点 %s 的配额为 %d 字节，写入后将占用 %d 字节", errQuotaExceeded, owner, nodeLimit, nodeUsed)
+	}
+	if globalLimit > 0 && globalUsed > globalLimit {
+		return fmt.Errorf("%w: 全局配额为 %d 字节，写入后将占用 %d 字节", errQuotaExceeded, globalLimit, globalUsed)
+	}
+	return nil
+}
+
+// checkDirectUpload 检查不经过上传会话、直接写入的上传（简单上传、同步上传）是否超出配额或磁盘水位线
+func checkDirectUpload(owner, name string, size int64) error {
+	models.UploadsMutex.Lock()
+	err := checkQuota(owner, name, size)
+	models.UploadsMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return checkDiskSpace(size)
+}
+
+// diskSpaceDirs 上传数据会写入的本地目录：临时目录，以及 local 后端的存储根目录
+func diskSpaceDirs() []string {
+	dirs := []string{relayConfig.Storage.TempDir}
+	if relayConfig.Storage.Backend != "s3" {
+		dirs = append(dirs, relayConfig.Storage.UploadsDir)
+	}
+	return dirs
+}
+
+// checkDiskSpace 检查写入 size 字节后磁盘剩余空间是否仍高于水位线
+// 无法获取剩余空间时不阻止上传
+func checkDiskSpace(size int64) error {
+	minFree := relayConfig.Quota.MinFreeBytes
+	if minFree == 0 {
+		return nil
+	}
+
+	for _, dir := range diskSpaceDirs() {
+		free, err := utils.DiskFree(dir)
+		if err != nil {
+			if !errors.Is(err, errors.ErrUnsupported) {
+				fmt.Printf("获取 %s 的剩余空间失败: %v\n", dir, err)
+			}
+			continue
+		}
+		if free-size < minFree {
+			return fmt.Errorf("%w: %s 剩余 %d 字节，写入 %d 字节后将低于 %d 字节", errDiskSpaceLow, dir, free, size, minFree)
+		}
+	}
+	return nil
+}
+
+// quotaStatus 配额检查失败时的 HTTP 状态码：超出配额为 413，磁盘空间不足为 507
+func quotaStatus(err error) int {
+	switch {
+	case errors.Is(err, errQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errDiskSpaceLow):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// getNodeUsage 获取节点的存储用量，reserved 为各上传者的预留空间
+func getNodeUsage(uid string, reserved map[string]int64) nodeUsage {
+	usage := models.OwnerUsage(uid)
+	return nodeUsage{
+		UID:           uid,
+		Files:         usage.Files,
+		UsedBytes:     usage.Bytes,
+		ReservedBytes: reserved[uid],
+		QuotaBytes:    relayConfig.Quota.NodeLimit(uid),
+	}
+}
+
+// GetNodeUsage 查询单个节点的存储用量和配额，由 manager 调用
+func GetNodeUsage(c *gin.Context) {
+	models.UploadsMutex.Lock()
+	reserved, _ := uploadReservations()
+	models.UploadsMutex.Unlock()
+
+	c.JSON(http.StatusOK, getNodeUsage(c.Param("uid"), reserved))
+}
+
+// ListNodeUsage 列出所有节点的存储用量，以及全局用量和磁盘剩余空间，由 manager 调用
+// 未提供上传者的文件只计入全局用量
+func ListNodeUsage(c *gin.Context) {
+	models.UploadsMutex.Lock()
+	reserved, totalReserved := uploadReservations()
+	models.UploadsMutex.Unlock()
+
+	uids := make(map[string]bool)
+	for uid := range models.AllUsage() {
+		uids[uid] = true
+	}
+	for uid := range reserved {
+		uids[uid] = true
+	}
+	delete(uids, "")
+
+	nodes := make([]nodeUsage, 0, len(uids))
+	for uid := range uids {
+		nodes = append(nodes, getNodeUsage(uid, reserved))
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].UID < nodes[j].UID
+	})
+
+	disks := []gin.H{}
+	for _, dir := range diskSpaceDirs() {
+		if free, err := utils.DiskFree(dir); err == nil {
+			disks = append(disks, gin.H{"path": dir, "free_bytes": free})
+		}
+	}
+
+	total := models.TotalUsage()
+	c.JSON(http.StatusOK, gin.H{
+		"nodes": nodes,
+		"global": gin.H{
+			"files":          total.Files,
+			"used_bytes":     total.Bytes,
+			"reserved_bytes": totalReserved,
+			"quota_bytes":    relayConfig.Quota.GlobalBytes,
+		},
+		"disks":          disks,
+		"min_free_bytes": relayConfig.Quota.MinFreeBytes,
+	})
+}
+
+// checkSessionQuota 写入上传会话的数据前重新检查配额和磁盘水位线，size 为本次写入的字节数
+// 会话初始化时已按声明的大小预留配额，只有其他上传并发抢占了配额时才会在这里失败
+func checkSessionQuota(info *models.UploadInfo, size int64) error {
+	models.UploadsMutex.Lock()
+	err := checkQuota(info.Owner, info.FileName, 0)
+	models.UploadsMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	// 按偏移量写入的会话已预分配磁盘空间
+	if info.Positional {
+		return nil
+	}
+	return checkDiskSpace(size)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"com.example/relay/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckQuota(t *testing.T) {
+	cfg := newTestEnv(t)
+	cfg.Quota.NodeBytes = 100
+	cfg.Quota.Nodes = map[string]int64{"big": 1000}
+	cfg.Quota.GlobalBytes = 300
+
+	// 节点 n1 已占用 60 字节，匿名文件占用 50 字节
+	putTestFile(t, "n1/a.txt", strings.Repeat("a", 60), "n1")
+	putTestFile(t, "anon.txt", strings.Repeat("b", 50), "")
+
+	tests := []struct {
+		name     string
+		owner    string
+		file     string
+		size     int64
+		reserved int64 // 节点 owner 进行中的上传会话预留的空间
+		exceeded bool
+	}{
+		{"节点配额以内", "n1", "n1/b.txt", 40, 0, false},
+		{"超出节点配额", "n1", "n1/b.txt", 41, 0, true},
+		{"替换自己的文件不计原大小", "n1", "n1/a.txt", 100, 0, false},
+		{"上传会话的预留空间计入配额", "n1", "n1/b.txt", 11, 30, true},
+		{"单独设置的节点配额", "big", "big/a.txt", 150, 0, false},
+		{"匿名上传不受节点配额限制", "", "c.txt", 150, 0, false},
+		{"匿名上传计入全局配额", "", "c.txt", 191, 0, true},
+		{"替换匿名文件不计原大小", "", "anon.txt", 240, 0, false},
+		{"节点上传同样受全局配额限制", "big", "big/a.txt", 191, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models.UploadsMutex.Lock()
+			defer models.UploadsMutex.Unlock()
+			if tt.reserved > 0 {
+				models.Uploads["reserved"] = &models.UploadInfo{FileID: "reserved", Owner: tt.owner, TotalSize: tt.reserved}
+				defer delete(models.Uploads, "reserved")
+			}
+
+			err := checkQuota(tt.owner, tt.file, tt.size)
+			if tt.exceeded != errors.Is(err, errQuotaExceeded) {
+				t.Fatalf("checkQuota(%q, %q, %d) = %v，期望超出配额 = %v", tt.owner, tt.file, tt.size, err, tt.exceeded)
+			}
+			if !tt.exceeded && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUploadOwner(t *testing.T) {
+	newTestEnv(t)
+	secret, err := models.CreateNode(&models.NodeInfo{UID: "n1", Name: "n1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		uid    string
+		token  string
+		owner  string
+		status int
+	}{
+		{"匿名上传", "", "", "", http.StatusOK},
+		{"匿名上传忽略凭证", "", "wrong", "", http.StatusOK},
+		{"声明节点并携带凭证", "n1", secret, "n1", http.StatusOK},
+		{"声明节点但没有凭证", "n1", "", "", http.StatusUnauthorized},
+		{"声明节点但凭证错误", "n1", "wrong", "", http.StatusUnauthorized},
+		{"声明未注册的节点", "n2", secret, "", http.StatusForbidden},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/file/upload", nil)
+			if tt.token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			owner, status, err := uploadOwner(c, tt.uid)
+			if owner != tt.owner || status != tt.status || (status == http.StatusOK) != (err == nil) {
+				t.Fatalf("uploadOwner(%q) = %q, %d, %v，期望 %q, %d", tt.uid, owner, status, err, tt.owner, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// rpcCall 等待节点响应的 RPC 请求
+type rpcCall struct {
+	uid      string       // 只接受该节点回复的响应
+	response chan TextMsg // 收到响应后写入，容量为 1，不会阻塞读协程
+}
+
+var (
+	// rpcCalls 等待响应的 RPC 请求，键为关联ID
+	rpcCalls = make(map[string]*rpcCall)
+	// rpcMutex 保护 rpcCalls
+	rpcMutex sync.Mutex
+)
+
+// NodeRPCRequest RPC 请求参数
+type NodeRPCRequest struct {
+	Method  string          `json:"method" binding:"required"` // 节点上执行的方法，如 status、diagnostics、config
+	Params  json.RawMessage `json:"params"`                    // 方法参数，原样转发给节点
+	Timeout string          `json:"timeout"`                   // 等待响应的时间，如 5s，未指定时使用 socket.rpc_timeout
+}
+
+// rpcTimeout 解析请求指定的超时时间，不超过 socket.rpc_max_timeout
+func rpcTimeout(timeout string) (time.Duration, bool) {
+	if timeout == "" {
+		return relayConfig.Socket.RPCTimeout, true
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return min(d, relayConfig.Socket.RPCMaxTimeout), true
+}
+
+// pickNodeClient 选择节点最新建立、仍未关闭的连接发送 RPC 请求，保证只有一个连接处理请求
+func pickNodeClient(uid string) *nodeClient {
+	clients := wsManager.GetNodeConnById(uid)
+	for i := len(clients) - 1; i >= 0; i-- {
+		if !clients[i].closed() {
+			return clients[i]
+		}
+	}
+	return nil
+}
+
+// NodeRPC 向节点发送 RPC 请求并同步等待响应，由 manager 调用
+// 请求帧为 {type: "rpc_request", id: 关联ID, data: {method, params}}，
+// 节点以 {type: "rpc_response", id: 关联ID, data: 结果, error: 错误信息} 回复
+// RPC 请求不会持久化，节点离线、超时或连接断开时直接返回错误，由调用方决定是否重试
+func NodeRPC(c *gin.Context) {
+	uid := c.Param("uid")
+
+	var req NodeRPCRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "参数不完整: " + err.Error(),
+		})
+		return
+	}
+	timeout, ok := rpcTimeout(req.Timeout)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "timeout 格式不正确",
+		})
+		return
+	}
+
+	client := pickNodeClient(uid)
+	if client == nil {
+		if _, exists, _ := models.GetNode(uid); !exists {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "节点未注册",
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "节点不在线",
+		})
+		return
+	}
+
+	id, err := utils.GenerateMessageID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "生成请求ID失败: " + err.Error(),
+		})
+		return
+	}
+	frame, err := json.Marshal(TextMsg{
+		Type: RPCRequest,
+		ID:   id,
+		Data: gin.H{
+			"method": req.Method,
+			"params": req.Params,
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "序列化请求失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 先登记再发送，避免节点响应早于登记
+	call := &rpcCall{uid: uid, response: make(chan TextMsg, 1)}
+	rpcMutex.Lock()
+	rpcCalls[id] = call
+	rpcMutex.Unlock()
+	defer func() {
+		rpcMutex.Lock()
+		delete(rpcCalls, id)
+		rpcMutex.Unlock()
+	}()
+
+	if err := client.enqueue(frame); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "发送请求失败: " + err.Error(),
+		})
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-call.response:
+		if resp.Error != "" {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "节点返回错误: " + resp.Error,
+				"id":    id,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id":     id,
+			"result": resp.Data,
+		})
+	case <-timer.C:
+		c.JSON(http.StatusGatewayTimeout, gin.H{
+			"error": "等待节点响应超时",
+			"id":    id,
+		})
+	case <-client.done:
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "节点连接已断开",
+			"id":    id,
+		})
+	case <-c.Request.Context().Done():
+		// 调用方已断开，无需响应
+	}
+}
+
+// resolveRPCCall 将节点回复的 RPC 响应交给等待中的请求，超时或未知的响应直接丢弃
+func resolveRPCCall(uid string, msg TextMsg) {
+	rpcMutex.Lock()
+	call, exists := rpcCalls[msg.ID]
+	if exists && call.uid == uid {
+		delete(rpcCalls, msg.ID)
+	}
+	rpcMutex.Unlock()
+
+	if !exists || call.uid != uid {
+		fmt.Printf("丢弃节点 %s 的 RPC 响应 %s: 请求不存在或已超时\n", uid, msg.ID)
+		return
+	}
+	call.response <- msg
+}
@@ -2,12 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"com.example/relay/models"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
@@ -19,23 +22,46 @@ const (
 	InitNode        NodeMsgType = "init_node"
 	InitNodeSuccess NodeMsgType = "init_node_success"
 	InitNodeFailed  NodeMsgType = "init_node_failed"
+	Sync            NodeMsgType = "sync"
+	Ack             NodeMsgType = "ack"  // 节点确认已处理 id 对应的消息
+	Nack            NodeMsgType = "nack" // 节点拒绝 id 对应的消息，data 为原因
+	RPCRequest      NodeMsgType = "rpc_request"
+	RPCResponse     NodeMsgType = "rpc_response" // 节点对 id 对应的 RPC 请求的响应
 )
 
 // WebSocketManager 管理所有WebSocket连接和相关操作
 // 将全局变量封装到结构体中，便于管理和测试
 type WebSocketManager struct {
 	// 从节点ID到WebSocket连接的映射
-	nodeConnections map[string][]*websocket.Conn
+	nodeConnections map[string][]*nodeClient
 	// 保护nodeConnections的互斥锁
 	connMutex sync.RWMutex
 	// WebSocket升级器
 	upgrader websocket.Upgrader
+
+	// 节点上线、离线事件的回调，以及待分发的事件，均由 connMutex 保护
+	presenceListeners []PresenceListener
+	presenceQueue     []presenceEvent
+	// 有新的上线、离线事件待分发
+	presenceSignal chan struct{}
+}
+
+// PresenceListener 节点上线或离线时的回调
+// online 为 true 表示节点建立了第一个连接，false 表示节点的最后一个连接已断开
+type PresenceListener func(uid string, online bool)
+
+// presenceEvent 节点上线或离线事件
+type presenceEvent struct {
+	uid    string
+	online bool
+	at     time.Time
 }
 
 // NewWebSocketManager 创建并初始化一个新的WebSocket管理器
 func NewWebSocketManager() *WebSocketManager {
-	return &WebSocketManager{
-		nodeConnections: make(map[string][]*websocket.Conn),
+	m := &WebSocketManager{
+		nodeConnections: make(map[string][]*nodeClient),
+		presenceSignal:  make(chan struct{}, 1),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -45,9 +71,55 @@ func NewWebSocketManager() *WebSocketManager {
 			},
 		},
 	}
+	go m.dispatchPresence()
+	return m
+}
+
+// OnPresenceChange 注册节点上线或离线时的回调
+// 回调在单独的协程中按事件发生的顺序依次执行，可以向节点发送消息
+func (m *WebSocketManager) OnPresenceChange(listener PresenceListener) {
+	m.connMutex.Lock()
+	defer m.connMutex.Unlock()
+
+	m.presenceListeners = append(m.presenceListeners, listener)
+}
+
+// queuePresence 记录节点上线或离线事件，调用方需持有 connMutex
+// 事件在持有连接锁时入队，保证与连接的增删顺序一致
+func (m *WebSocketManager) queuePresence(uid string, online bool) {
+	m.presenceQueue = append(m.presenceQueue, presenceEvent{uid: uid, online: online, at: time.Now()})
+	select {
+	case m.presenceSignal <- struct{}{}:
+	default:
+	}
 }
 
-func (m *WebSocketManager) GetNodeConnById(uid string) []*websocket.Conn {
+// dispatchPresence 依次分发节点上线、离线事件：记录到节点注册表并通知回调
+func (m *WebSocketManager) dispatchPresence() {
+	for range m.presenceSignal {
+		m.connMutex.Lock()
+		events := m.presenceQueue
+		listeners := m.presenceListeners
+		m.presenceQueue = nil
+		m.connMutex.Unlock()
+
+		for _, event := range events {
+			if event.online {
+				fmt.Printf("节点 %s 已上线\n", event.uid)
+			} else {
+				fmt.Printf("节点 %s 已离线\n", event.uid)
+			}
+			if err := models.SetNodePresence(event.uid, event.online, event.at); err != nil {
+				fmt.Printf("记录节点 %s 的在线状态失败: %v\n", event.uid, err)
+			}
+			for _, listener := range listeners {
+				listener(event.uid, event.online)
+			}
+		}
+	}
+}
+
+func (m *WebSocketManager) GetNodeConnById(uid string) []*nodeClient {
 	m.connMutex.RLock()
 	defer m.connMutex.RUnlock()
 
@@ -60,6 +132,9 @@ var wsManager = NewWebSocketManager()
 // SetupSocketRoutes 设置WebSocket相关路由
 func SetupSocketRoutes(router *gin.RouterGroup) {
 	router.GET("/node/:uid", HandleNodeSocket)
+
+	// 节点重新连接后补发离线期间的消息
+	wsManager.OnPresenceChange(replayOutboxOnConnect)
 }
 
 // HandleNodeSocket 处理节点WebSocket连接请求
@@ -74,10 +149,10 @@ func HandleNodeSocket(c *gin.Context) {
 		return
 	}
 
-	// 验证节点ID的有效性
-	if !checkUid(uid) {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的节点ID",
+	// 在升级连接之前验证节点身份
+	if status, err := authenticateNode(c, uid); err != nil {
+		c.JSON(status, gin.H{
+			"error": "节点认证失败: " + err.Error(),
 		})
 		return
 	}
@@ -91,23 +166,27 @@ func HandleNodeSocket(c *gin.Context) {
 		return
 	}
 
-	// 将WebSocket连接添加到对应节点的连接列表
-	wsManager.AddConnection(uid, ws)
+	// 将WebSocket连接添加到对应节点的连接列表，写操作由连接自己的写协程完成
+	client := newNodeClient(uid, ws)
+	wsManager.AddConnection(uid, client)
 
 	// 在单独的goroutine中处理连接
-	go wsManager.handleConnection(ws, uid)
+	go wsManager.handleConnection(client)
 }
 
 // AddConnection 添加WebSocket连接到指定节点
-func (m *WebSocketManager) AddConnection(uid string, ws *websocket.Conn) {
+func (m *WebSocketManager) AddConnection(uid string, client *nodeClient) {
 	m.connMutex.Lock()
 	defer m.connMutex.Unlock()
 
-	m.nodeConnections[uid] = append(m.nodeConnections[uid], ws)
+	m.nodeConnections[uid] = append(m.nodeConnections[uid], client)
+	if len(m.nodeConnections[uid]) == 1 {
+		m.queuePresence(uid, true)
+	}
 }
 
 // RemoveConnection 从指定节点移除WebSocket连接
-func (m *WebSocketManager) RemoveConnection(uid string, ws *websocket.Conn) {
+func (m *WebSocketManager) RemoveConnection(uid string, client *nodeClient) {
 	m.connMutex.Lock()
 	defer m.connMutex.Unlock()
 
@@ -117,17 +196,22 @@ func (m *WebSocketManager) RemoveConnection(uid string, ws *websocket.Conn) {
 	}
 
 	// 查找并移除指定连接
+	removed := false
 	for i, conn := range connections {
-		if conn == ws {
+		if conn == client {
 			// 使用切片操作移除元素
 			m.nodeConnections[uid] = append(connections[:i], connections[i+1:]...)
+			removed = true
 			break
 		}
 	}
 
-	// 如果节点没有剩余连接，则删除该节点的映射
+	// 如果节点没有剩余连接，则删除该节点的映射，节点离线
 	if len(m.nodeConnections[uid]) == 0 {
 		delete(m.nodeConnections, uid)
+		if removed {
+			m.queuePresence(uid, false)
+		}
 	}
 }
 
@@ -137,61 +221,79 @@ func SendMessageToNode(uid string, message []byte) {
 	wsManager.SendMessage(uid, message)
 }
 
+// errNodeOffline 节点没有可用的连接，消息未送达任何连接
+var errNodeOffline = errors.New("节点不在线")
+
 // SendMessage 向指定节点的所有连接发送消息
+// 消息放入各连接的待发送队列后即返回，不等待写出；没有任何连接接收消息时返回 errNodeOffline
 func (m *WebSocketManager) SendMessage(uid string, message []byte) error {
 	m.connMutex.RLock()
-	defer m.connMutex.RUnlock()
-
 	connections, exists := m.nodeConnections[uid]
-	if !exists {
-		return fmt.Errorf("节点 %s 不存在", uid)
-	}
-
-	var wg sync.WaitGroup
-	errors := make(chan error, len(connections))
-
-	wg.Add(len(connections))
+	connections = append([]*nodeClient(nil), connections...)
+	m.connMutex.RUnlock()
 
-	for _, conn := range connections {
-		// 使用非阻塞方式发送消息，避免一个连接卡住影响其他连接
-		go func(c *websocket.Conn, msg []byte) {
-			defer wg.Done()
-			if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
-				// 发送失败时记录错误，但不中断其他连接的发送
-				fmt.Printf("向节点 %s 发送消息失败: %v\n", uid, err)
-				errors <- err
-			}
-		}(conn, message)
+	if !exists {
+		return fmt.Errorf("节点 %s 不存在: %w", uid, errNodeOffline)
 	}
-	wg.Wait()
-	close(errors)
 
-	if len(errors) > 0 {
-		errorMessages := []string{}
-
-		for err := range errors {
+	errorMessages := []string{}
+	for _, client := range connections {
+		// 一个连接的队列已满不影响其他连接
+		if err := client.enqueue(message); err != nil {
 			errorMessages = append(errorMessages, err.Error())
 		}
+	}
+
+	if len(errorMessages) == len(connections) {
+		return fmt.Errorf("向节点 %s 发送消息失败: %v: %w", uid, strings.Join(errorMessages, ", "), errNodeOffline)
+	}
+	if len(errorMessages) > 0 {
 		return fmt.Errorf("向节点 %s 发送消息失败: %v", uid, strings.Join(errorMessages, ", "))
 	}
 
 	return nil
 }
 
-// checkUid 验证节点ID的有效性
-func checkUid(uid string) bool {
-	// TODO: 实现更严格的节点ID验证逻辑
-	// 例如：检查格式、查询数据库验证存在性等
-	fmt.Println("正在验证节点ID:", uid)
-	return true
+// waitSendRoom 等待节点所有连接的待发送队列都有空位，返回可以放入的消息数；节点离线时返回 false
+// 已关闭但尚未移除的连接不再接收消息，视为离线；队列已满时等待该连接的写协程腾出空位或连接关闭，不轮询
+func (m *WebSocketManager) waitSendRoom(uid string) (int, bool) {
+	for {
+		m.connMutex.RLock()
+		var full *nodeClient
+		live := 0
+		room := relayConfig.Socket.SendQueueSize
+		for _, client := range m.nodeConnections[uid] {
+			if client.closed() {
+				continue
+			}
+			live++
+			if r := client.room(); r < room {
+				room = r
+			}
+			if room == 0 && full == nil {
+				full = client
+			}
+		}
+		m.connMutex.RUnlock()
+
+		if live == 0 {
+			return 0, false
+		}
+		if full == nil {
+			return room, true
+		}
+		full.waitDrained()
+	}
 }
 
-// handleConnection 处理WebSocket连接的生命周期
-func (m *WebSocketManager) handleConnection(ws *websocket.Conn, uid string) {
+// handleConnection 处理WebSocket连接的生命周期，读循环在此执行，写操作交给连接的写协程
+func (m *WebSocketManager) handleConnection(client *nodeClient) {
+	ws, uid := client.conn, client.uid
+
 	// 确保连接关闭和资源清理
 	defer func() {
-		ws.Close()
-		m.RemoveConnection(uid, ws)
+		client.close()
+		m.RemoveConnection(uid, client)
 		fmt.Printf("节点 %s 的一个连接已关闭\n", uid)
 	}()
 
@@ -199,12 +301,13 @@ func (m *WebSocketManager) handleConnection(ws *websocket.Conn, uid string) {
 	ws.SetCloseHandler(func(code int, text string) error {
 		fmt.Printf("节点 %s 的连接正常关闭，代码: %d, 原因: %s\n", uid, code, text)
 		// 关闭连接时移除该连接
-		m.RemoveConnection(uid, ws)
+		m.RemoveConnection(uid, client)
 		return nil
 	})
 
 	// 设置Ping处理器，保持连接活跃
 	ws.SetPingHandler(func(appData string) error {
+		client.extendReadDeadline()
 		// 响应Ping消息，发送Pong
 		err := ws.WriteControl(websocket.PongMessage, []byte{}, time.Now().Add(10*time.Second))
 		if err != nil {
@@ -213,11 +316,21 @@ func (m *WebSocketManager) handleConnection(ws *websocket.Conn, uid string) {
 		return err
 	})
 
+	// 写协程定期发送 ping，收到 pong 或任何消息都说明连接仍然可用；超时未收到数据时读取失败，连接被移除
+	ws.SetPongHandler(func(appData string) error {
+		client.extendReadDeadline()
+		return nil
+	})
+	client.extendReadDeadline()
+
 	// 持续读取消息，直到连接关闭
 	for {
 		msgType, msg, err := ws.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err,
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				fmt.Printf("节点 %s 心跳超时，断开连接\n", uid)
+			} else if websocket.IsUnexpectedCloseError(err,
 				websocket.CloseGoingAway,
 				websocket.CloseNormalClosure,
 				websocket.CloseNoStatusReceived) {
@@ -226,21 +339,30 @@ func (m *WebSocketManager) handleConnection(ws *websocket.Conn, uid string) {
 			break
 		}
 
+		// 连接已因写入失败或队列已满被关闭，不再处理残留的消息
+		if client.closed() {
+			break
+		}
+		client.extendReadDeadline()
+
 		// 处理文本消息
 		if msgType == websocket.TextMessage {
-			m.handleTextMsg(ws, msg, uid)
+			m.handleTextMsg(client, msg)
 		}
 	}
 }
 
 // TextMsg 定义WebSocket文本消息的基本结构
 type TextMsg struct {
-	Type NodeMsgType `json:"type"`           // 消息类型
-	Data interface{} `json:"data,omitempty"` // 消息数据
+	Type  NodeMsgType `json:"type"`            // 消息类型
+	ID    string      `json:"id,omitempty"`    // 消息ID，服务端投递的消息都带有ID，节点回复 ack/nack 时携带；RPC 请求和响应以此关联
+	Data  interface{} `json:"data,omitempty"`  // 消息数据
+	Error string      `json:"error,omitempty"` // RPC 响应的错误信息
 }
 
 // handleTextMsg 处理接收到的文本消息
-func (m *WebSocketManager) handleTextMsg(ws *websocket.Conn, msg []byte, uid string) {
+func (m *WebSocketManager) handleTextMsg(client *nodeClient, msg []byte) {
+	uid := client.uid
 	var textMsg TextMsg
 	err := json.Unmarshal(msg, &textMsg)
 	if err != nil {
@@ -251,17 +373,40 @@ func (m *WebSocketManager) handleTextMsg(ws *websocket.Conn, msg []byte, uid str
 	// 根据消息类型分发处理
 	switch textMsg.Type {
 	case Ping:
-		m.handlePing(ws)
+		m.handlePing(client)
 	case InitNode:
 		m.handleInitNode(textMsg.Data)
+	case InitNodeSuccess:
+		m.handleInitNodeResult(uid, models.NodeInitialized)
+	case InitNodeFailed:
+		m.handleInitNodeResult(uid, models.NodeInitFailed)
+	case Ack:
+		finishDelivery(uid, textMsg.ID, models.DeliveryAcked, "")
+	case Nack:
+		finishDelivery(uid, textMsg.ID, models.DeliveryNacked, nackReason(textMsg.Data))
+	case RPCResponse:
+		resolveRPCCall(uid, textMsg)
 	// 可以在此添加更多消息类型的处理分支
 	default:
 		fmt.Printf("收到未知类型的消息: %s\n", textMsg.Type)
 	}
 }
 
+// nackReason 将 nack 消息的 data 转换为拒绝原因
+func nackReason(data interface{}) string {
+	switch v := data.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		bytes, _ := json.Marshal(v)
+		return string(bytes)
+	}
+}
+
 // handlePing 处理ping消息，回复pong消息
-func (m *WebSocketManager) handlePing(ws *websocket.Conn) {
+func (m *WebSocketManager) handlePing(client *nodeClient) {
 	resp := map[string]string{
 		"type":      "pong",
 		"timestamp": time.Now().Format(time.RFC3339),
@@ -273,7 +418,7 @@ func (m *WebSocketManager) handlePing(ws *websocket.Conn) {
 		return
 	}
 
-	if err := ws.WriteMessage(websocket.TextMessage, bytes); err != nil {
+	if err := client.enqueue(bytes); err != nil {
 		fmt.Println("发送pong消息失败:", err)
 	}
 }
@@ -291,36 +436,14 @@ func (m *WebSocketManager) handleInitNode(data interface{}) {
 		return
 	}
 
-	initNodeMsg := TextMsg{
-		Type: InitNode,
-	}
-
-	initNodeBytes, err := json.Marshal(initNodeMsg)
-	if err != nil {
-		fmt.Printf("序列化初始化节点消息失败: %v\n", err)
-		return
-	}
-
-	// 发送序列化后的消息
-	if err := wsManager.SendMessage(uid, initNodeBytes); err != nil {
-		fmt.Printf("向节点 %s 发送初始化成功消息失败: %v\n", uid, err)
-	}
-
-	// 创建初始化成功消息
-	initSuccessMsg := TextMsg{
-		Type: InitNodeSuccess,
-		Data: uid,
-	}
-
-	// 将消息序列化为JSON
-	initSuccessBytes, err := json.Marshal(initSuccessMsg)
-	if err != nil {
-		fmt.Printf("序列化初始化成功消息失败: %v\n", err)
-		return
+	if _, err := deliverMessage(uid, TextMsg{Type: InitNode}, 0, 0); err != nil {
+		fmt.Printf("向节点 %s 发送初始化节点消息失败: %v\n", uid, err)
 	}
+}
 
-	// 向111111节点也发送相同的消息
-	if err := wsManager.SendMessage("111111", initSuccessBytes); err != nil {
-		fmt.Printf("向节点 111111 发送初始化成功消息失败: %v\n", err)
+// handleInitNodeResult 处理节点回复的初始化结果，更新注册表中的生命周期状态
+func (m *WebSocketManager) handleInitNodeResult(uid string, state models.NodeState) {
+	if _, err := models.TransitionNode(uid, state); err != nil {
+		fmt.Printf("更新节点 %s 初始化状态失败: %v\n", uid, err)
 	}
 }
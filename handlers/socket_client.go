@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"com.example/relay/config"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// errClientClosed 连接已关闭，消息无法再发送
+	errClientClosed = errors.New("连接已关闭")
+	// errSlowConsumer 待发送队列已满，按 disconnect 策略断开了连接
+	errSlowConsumer = errors.New("待发送消息队列已满，已断开连接")
+)
+
+// closeGracePeriod 断开连接时发送关闭帧的超时时间
+const closeGracePeriod = time.Second
+
+// nodeClient 节点的一个WebSocket连接
+// gorilla/websocket 同一时间只允许一个写入方，所有消息先进入有界队列，再由 writePump 串行写出
+type nodeClient struct {
+	uid  string
+	conn *websocket.Conn
+
+	send    chan []byte   // 待发送的消息，不关闭，以 done 通知 writePump 退出
+	done    chan struct{} // 连接关闭时关闭
+	drained chan struct{} // writePump 取出消息、队列腾出空位时通知，容量为 1，通知不会阻塞也不会丢失
+
+	sendMu    sync.Mutex // 串行化入队，保证 drop_oldest 策略丢弃一条后一定能放入新消息
+	dropped   int        // 按 drop_oldest 策略丢弃的消息数，由 sendMu 保护
+	closeOnce sync.Once
+}
+
+// newNodeClient 创建连接并启动写协程
+func newNodeClient(uid string, conn *websocket.Conn) *nodeClient {
+	client := &nodeClient{
+		uid:     uid,
+		conn:    conn,
+		send:    make(chan []byte, relayConfig.Socket.SendQueueSize),
+		done:    make(chan struct{}),
+		drained: make(chan struct{}, 1),
+	}
+	go client.writePump()
+	return client
+}
+
+// enqueue 将消息放入待发送队列，不会阻塞
+// 队列已满时按 socket.queue_policy 丢弃最早的消息，或断开连接并返回 errSlowConsumer
+func (c *nodeClient) enqueue(msg []byte) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed() {
+		return errClientClosed
+	}
+
+	select {
+	case c.send <- msg:
+		return nil
+	default:
+	}
+
+	if relayConfig.Socket.QueuePolicy == config.QueueDisconnect {
+		fmt.Printf("节点 %s 的连接待发送消息过多，断开连接\n", c.uid)
+		c.close()
+		return errSlowConsumer
+	}
+
+	// 入队都在 sendMu 内进行，取出一条后队列一定有空位
+	select {
+	case <-c.send:
+		if c.dropped++; c.dropped == 1 {
+			fmt.Printf("节点 %s 的连接待发送消息过多，开始丢弃最早的消息\n", c.uid)
+		}
+	default:
+	}
+	c.send <- msg
+	return nil
+}
+
+// writePump 依次写出待发送的消息并定期发送 ping，每次写入都有写超时，写入失败或超时即关闭连接
+func (c *nodeClient) writePump() {
+	defer func() {
+		c.conn.Close()
+		c.sendMu.Lock()
+		if c.dropped > 0 {
+			fmt.Printf("节点 %s 的连接共丢弃 %d 条消息\n", c.uid, c.dropped)
+		}
+		c.sendMu.Unlock()
+	}()
+
+	ticker := time.NewTicker(relayConfig.Socket.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(relayConfig.Socket.WriteTimeout)); err != nil {
+				fmt.Printf("向节点 %s 发送心跳失败: %v\n", c.uid, err)
+				c.close()
+				return
+			}
+		case msg := <-c.send:
+			c.notifyDrained()
+			c.conn.SetWriteDeadline(time.Now().Add(relayConfig.Socket.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				fmt.Printf("向节点 %s 发送消息失败: %v\n", c.uid, err)
+				c.close()
+				return
+			}
+		case <-c.done:
+			// WriteControl 可以与其他写操作并发调用，这里只是尽力通知对端
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(closeGracePeriod))
+			return
+		}
+	}
+}
+
+// extendReadDeadline 收到节点的数据后延长读超时，只能在读协程中调用
+func (c *nodeClient) extendReadDeadline() {
+	c.conn.SetReadDeadline(time.Now().Add(relayConfig.Socket.PingInterval + relayConfig.Socket.PongTimeout))
+}
+
+// notifyDrained 通知等待队列空位的协程，已有未读取的通知时不再重复发送
+func (c *nodeClient) notifyDrained() {
+	select {
+	case c.drained <- struct{}{}:
+	default:
+	}
+}
+
+// waitDrained 等待队列腾出空位或连接关闭
+func (c *nodeClient) waitDrained() {
+	select {
+	case <-c.drained:
+	case <-c.done:
+	}
+}
+
+// room 待发送队列的剩余空位，连接已关闭时为 0
+func (c *nodeClient) room() int {
+	if c.closed() {
+		return 0
+	}
+	return cap(c.send) - len(c.send)
+}
+
+// closed 连接是否已关闭
+func (c *nodeClient) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close 关闭连接，可重复调用；writePump 退出时关闭底层连接，读循环随之结束
+func (c *nodeClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// newQueuedClient 创建只有待发送队列、没有写协程的连接，队列中预先放入 queued 条消息
+func newQueuedClient(size, queued int) *nodeClient {
+	client := &nodeClient{
+		send:    make(chan []byte, size),
+		done:    make(chan struct{}),
+		drained: make(chan struct{}, 1),
+	}
+	for i := 0; i < queued; i++ {
+		client.send <- []byte("msg")
+	}
+	return client
+}
+
+func TestWaitSendRoom(t *testing.T) {
+	cfg := newTestEnv(t)
+	cfg.Socket.SendQueueSize = 2
+
+	tests := []struct {
+		name    string
+		clients []*nodeClient
+		closed  bool                        // 等待之前关闭第一个连接
+		release func(clients []*nodeClient) // 等待期间在后台执行，腾出空位或关闭连接
+		room    int
+		online  bool
+	}{
+		{"没有连接", nil, false, nil, 0, false},
+		{"队列为空", []*nodeClient{newQueuedClient(2, 0)}, false, nil, 2, true},
+		{"取各连接的最小空位", []*nodeClient{newQueuedClient(2, 0), newQueuedClient(2, 1)}, false, nil, 1, true},
+		{"已关闭的连接视为离线", []*nodeClient{newQueuedClient(2, 2)}, true, nil, 0, false},
+		{"忽略已关闭的连接", []*nodeClient{newQueuedClient(2, 2), newQueuedClient(2, 0)}, true, nil, 2, true},
+		{"等待写协程腾出空位", []*nodeClient{newQueuedClient(2, 2)}, false, func(clients []*nodeClient) {
+			<-clients[0].send
+			clients[0].notifyDrained()
+		}, 1, true},
+		{"等待期间连接关闭", []*nodeClient{newQueuedClient(2, 2)}, false, func(clients []*nodeClient) {
+			clients[0].close()
+		}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewWebSocketManager()
+			if len(tt.clients) > 0 {
+				manager.nodeConnections["n1"] = tt.clients
+			}
+			if tt.closed {
+				tt.clients[0].close()
+			}
+			if tt.release != nil {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					tt.release(tt.clients)
+				}()
+			}
+
+			result := make(chan [2]any, 1)
+			go func() {
+				room, online := manager.waitSendRoom("n1")
+				result <- [2]any{room, online}
+			}()
+			select {
+			case got := <-result:
+				if got[0] != tt.room || got[1] != tt.online {
+					t.Fatalf("waitSendRoom = %v, %v，期望 %d, %v", got[0], got[1], tt.room, tt.online)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("waitSendRoom 没有返回")
+			}
+		})
+	}
+}
@@ -1,13 +1,16 @@
 package handlers
 
 import (
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
+	"strings"
+	"time"
 
-	"com.example/relay/config"
+	"com.example/relay/models"
+	"com.example/relay/storage"
+	"com.example/relay/utils"
 	"github.com/gin-gonic/gin"
 )
 
@@ -29,41 +32,94 @@ func SyncUpload(c *gin.Context) {
 	filename := c.PostForm("filename")
 	if filename == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无法获取同步的资源名称"})
+		return
+	}
+	// 同步文件保存在以节点 uid 命名的目录下，uid 只能是单个路径段
+	if cleaned, err := utils.CleanFilePath(uid); err != nil || cleaned != uid || strings.Contains(uid, "/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "非法的节点信息"})
+		return
+	}
+	name, ok := bindFileName(c, path.Join(uid, filename))
+	if !ok {
+		return
+	}
+	// 同步通知只能投递给已注册的节点，先检查再保存文件
+	if _, exists, err := models.GetNode(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询节点失败"})
+		return
+	} else if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "节点不存在"})
+		return
+	}
+	// 同步文件由 manager 推送时为匿名上传，只计入全局配额；携带节点 uid 的凭证时计入该节点的配额
+	owner := ""
+	if hasNodeCredential(c) {
+		if status, err := authenticateNode(c, uid); err != nil {
+			c.JSON(status, gin.H{"error": "节点认证失败: " + err.Error()})
+			return
+		}
+		owner = uid
 	}
 	file, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无法获取上传的文件"})
 		return
 	}
+	if err := checkDirectUpload(owner, name, file.Size); err != nil {
+		c.JSON(quotaStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
-	// 写入到 uploads/resource_name
-	filePath := filepath.Join(config.UploadsDir, uid, filename)
-	if err := c.SaveUploadedFile(file, filePath); err != nil {
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取上传文件失败"})
+		return
+	}
+	defer src.Close()
+
+	// 先写入暂存区，再以 uid/resource_name 为名纳入内容寻址存储，同步文件允许覆盖
+	ctx := c.Request.Context()
+	staged, err := stageObject(ctx, "sync-"+utils.GenerateFileID(filename, file.Size), src, file.Size, utils.DefaultHashAlgo)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
 		return
 	}
 
-	// 发送 {type: "sync", data: {uid: uid, resource_name: resource_name}}
+	if _, _, err := commitObject(ctx, staged, name, owner, true); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+		return
+	}
 
-	message := map[string]interface{}{
-		"type": "sync",
-		"data": map[string]string{
+	// 记录同步任务，节点确认同步完成之前文件不能被删除或移动
+	if err := models.SaveSyncJob(&models.SyncJob{
+		Name:      name,
+		UID:       uid,
+		FileName:  filename,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存同步任务失败"})
+		return
+	}
+
+	// 发送 {type: "sync", id: message_id, data: {uid: uid, filename: filename}}
+	message := TextMsg{
+		Type: Sync,
+		Data: map[string]string{
 			"uid":      uid,
 			"filename": filename,
 		},
 	}
 
-	jsonBytes, err := json.Marshal(message)
-	if err != nil {
-		// 根据您的错误处理策略处理错误
-		log.Printf("JSON marshal error: %v", err)
-		return
+	// 节点离线时同步通知保留在待投递队列，节点重新连接后补发；节点以 ack 确认收到
+	queued := len(wsManager.GetNodeConnById(uid)) == 0
+	response := gin.H{"message": "同步请求已发送", "queued": queued}
+	if record, err := deliverMessage(uid, message, 0, 0); err != nil {
+		fmt.Printf("向节点 %s 发送同步通知失败: %v\n", uid, err)
+	} else {
+		response["message_id"] = record.ID
 	}
 
-	wsManager.SendMessage(uid, jsonBytes)
-
-	c.JSON(http.StatusOK, gin.H{"message": "同步请求已发送"})
-
+	c.JSON(http.StatusOK, response)
 }
 
 // SyncDownload 同步下载文件
@@ -74,15 +130,32 @@ func SyncDownload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无法获取同步的资源名称"})
 		return
 	}
+	filename, ok := bindFileName(c, filename)
+	if !ok {
+		return
+	}
 
-	filePath := filepath.Join(config.UploadsDir, filename)
+	fileKey, fileMeta, err := resolveFile(filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询文件索引失败"})
+		return
+	}
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	fileInfo, err := fileStorage.Stat(c.Request.Context(), fileKey)
+	if errors.Is(err, storage.ErrNotExist) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
 		return
 	}
+	if errors.Is(err, storage.ErrInvalidKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "非法的文件路径"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取文件信息失败"})
+		return
+	}
 
-	c.File(filePath)
+	serveFile(c, fileInfo, filename, fileMeta)
 }
 
 // SyncComplete 同步完成，删除文件
@@ -94,13 +167,28 @@ func SyncComplete(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无法获取同步的节点信息或资源名称"})
 		return
 	}
+	filename, ok := bindFileName(c, filename)
+	if !ok {
+		return
+	}
 
-	filePath := filepath.Join(config.UploadsDir, filename)
-
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	// 删除文件索引，内容不再被引用时才真正删除
+	models.FilesMutex.Lock()
+	removed, err := removeFile(c.Request.Context(), filename)
+	models.FilesMutex.Unlock()
+	if errors.Is(err, storage.ErrInvalidKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "非法的文件路径"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除文件失败"})
+		return
+	}
+	if err := models.RemoveSyncJob(filename); err != nil {
+		fmt.Printf("删除同步任务 %s 失败: %v\n", filename, err)
+	}
+	if !removed {
 		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
 		return
 	}
-
-	os.Remove(filePath)
 }
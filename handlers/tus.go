@@ -0,0 +1,595 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.example/relay/models"
+	"com.example/relay/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// tus 1.0 协议常量
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum,expiration"
+
+	// StatusChecksumMismatch tus checksum 扩展定义的校验失败状态码
+	StatusChecksumMismatch = 460
+)
+
+// TusHeaders 浏览器端 tus 客户端需要读写的请求头，用于 CORS 配置
+var TusHeaders = []string{
+	"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Tus-Checksum-Algorithm",
+	"Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Checksum", "Upload-Expires",
+	"Location",
+}
+
+var (
+	// errTusChecksumMismatch 数据校验和不匹配
+	errTusChecksumMismatch = errors.New("校验和不匹配")
+	// errTusBodyTooLarge PATCH 请求体超出了剩余的上传长度
+	errTusBodyTooLarge = errors.New("请求体超出剩余的上传长度")
+)
+
+// SetupTusRoutes 设置 tus 协议路由
+// tus 会话与 /upload/init 创建的会话共享同一套存储和会话模型
+func SetupTusRoutes(router *gin.RouterGroup) {
+	router.Use(tusResumable)
+
+	router.OPTIONS("", TusOptions)
+	router.OPTIONS("/", TusOptions)
+	router.OPTIONS("/:id", TusOptions)
+
+	// creation 扩展
+	router.POST("", TusCreate)
+	router.POST("/", TusCreate)
+
+	// 核心协议
+	router.HEAD("/:id", TusHead)
+	router.PATCH("/:id", TusPatch)
+
+	// termination 扩展
+	router.DELETE("/:id", TusTerminate)
+}
+
+// tusResumable 为所有响应添加 Tus-Resumable 头，并校验客户端的协议版本
+func tusResumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+
+	if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+
+	c.Next()
+}
+
+// TusOptions 返回服务端支持的协议版本和扩展
+func TusOptions(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Checksum-Algorithm", strings.Join(utils.HashAlgorithms(), ","))
+	if max := relayConfig.Upload.TusMaxSize; max > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// TusCreate 创建上传会话（creation 扩展）
+// 支持的 Upload-Metadata 键：filename/name（文件名）、filehash（文件哈希值）、hashalgo（filehash 的算法，默认MD5）、
+// owner（上传者节点ID，请求需同时携带该节点的凭证）、overwrite（为 true 时允许覆盖内容不同的同名文件）
+func TusCreate(c *gin.Context) {
+	fileSize, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || fileSize < 0 {
+		c.String(http.StatusBadRequest, "Upload-Length 格式不正确")
+		return
+	}
+	if max := relayConfig.Upload.TusMaxSize; max > 0 && fileSize > max {
+		c.Header("Tus-Max-Size", strconv.FormatInt(max, 10))
+		c.String(http.StatusRequestEntityTooLarge, "Upload-Length 超过 Tus-Max-Size（%d 字节）", max)
+		return
+	}
+
+	metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Upload-Metadata 格式不正确: %s", err.Error())
+		return
+	}
+
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+	if fileName == "" {
+		c.String(http.StatusBadRequest, "Upload-Metadata 中缺少 filename")
+		return
+	}
+	fileName, err = cleanFileName(fileName)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashAlgo, err := utils.ParseHashAlgo(metadata["hashalgo"])
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	owner, status, err := uploadOwner(c, metadata["owner"])
+	if err != nil {
+		c.String(status, "节点认证失败: %s", err.Error())
+		return
+	}
+
+	// 避免覆盖内容不同的同名文件，接收完数据后提交时会再次检查
+	fileHash := strings.ToLower(metadata["filehash"])
+	overwrite := metadata["overwrite"] == "true"
+	if err := checkFileConflict(fileName, fileHash, hashAlgo, overwrite); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errFileExists) {
+			status = http.StatusConflict
+		}
+		c.String(status, err.Error())
+		return
+	}
+
+	chunkSize := relayConfig.Upload.DefaultChunkSize
+	totalChunks := int((fileSize + chunkSize - 1) / chunkSize)
+	now := time.Now()
+
+	uploadInfo := &models.UploadInfo{
+		FileID:      utils.GenerateFileID(fileName, fileSize),
+		FileName:    fileName,
+		TotalChunks: totalChunks,
+		TotalSize:   fileSize,
+		ChunkSize:   chunkSize,
+		Completed:   make([]bool, totalChunks),
+		FileHash:    fileHash,
+		HashAlgo:    hashAlgo,
+		Owner:       owner,
+		Overwrite:   overwrite,
+		ChunkHashes: make(map[int]string),
+		CreatedAt:   now,
+		Protocol:    models.ProtocolTus,
+		Metadata:    metadata,
+		ExpiresAt:   now.Add(relayConfig.Upload.TusExpiration),
+	}
+
+	// 按 Upload-Length 预留配额，检查与登记会话在同一把锁内完成
+	models.UploadsMutex.Lock()
+	err = checkQuota(uploadInfo.Owner, fileName, fileSize)
+	if err == nil {
+		err = checkDiskSpace(fileSize)
+	}
+	if err != nil {
+		models.UploadsMutex.Unlock()
+		c.String(quotaStatus(err), err.Error())
+		return
+	}
+	if err := models.SaveUploadInfo(uploadInfo); err != nil {
+		models.UploadsMutex.Unlock()
+		c.String(http.StatusInternalServerError, "保存上传会话失败: %s", err.Error())
+		return
+	}
+	models.Uploads[uploadInfo.FileID] = uploadInfo
+	models.UploadsMutex.Unlock()
+
+	// 空文件无需 PATCH，创建即完成
+	if fileSize == 0 {
+		if err := finishTusUpload(c.Request.Context(), uploadInfo); err != nil {
+			c.String(tusFinishStatus(err), err.Error())
+			return
+		}
+	}
+
+	c.Header("Location", tusLocation(c, uploadInfo.FileID))
+	c.Header("Upload-Expires", uploadInfo.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// TusHead 查询上传偏移量
+func TusHead(c *gin.Context) {
+	uploadInfo, ok := getTusUpload(c)
+	if !ok {
+		return
+	}
+
+	uploadInfo.WriteMu.Lock()
+	offset := tusOffset(uploadInfo)
+	uploadInfo.WriteMu.Unlock()
+
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(uploadInfo.TotalSize, 10))
+	if len(uploadInfo.Metadata) > 0 {
+		c.Header("Upload-Metadata", encodeTusMetadata(uploadInfo.Metadata))
+	}
+	c.Header("Upload-Expires", uploadInfo.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// TusPatch 从指定偏移量追加数据
+func TusPatch(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.String(http.StatusUnsupportedMediaType, "Content-Type 必须为 application/offset+octet-stream")
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.String(http.StatusBadRequest, "Upload-Offset 格式不正确")
+		return
+	}
+
+	// 解析 checksum 扩展的 Upload-Checksum 头：<算法> <Base64摘要>
+	var hasher hash.Hash
+	var expectedSum []byte
+	if checksum := c.GetHeader("Upload-Checksum"); checksum != "" {
+		name, encoded, _ := strings.Cut(checksum, " ")
+		algo, err := utils.ParseHashAlgo(name)
+		if err != nil || name == "" {
+			c.String(http.StatusBadRequest, "不支持的校验算法: %s", name)
+			return
+		}
+		hasher = utils.NewHash(algo)
+		if expectedSum, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			c.String(http.StatusBadRequest, "Upload-Checksum 格式不正确")
+			return
+		}
+	}
+
+	uploadInfo, ok := getTusUpload(c)
+	if !ok {
+		return
+	}
+
+	uploadInfo.WriteMu.Lock()
+	defer uploadInfo.WriteMu.Unlock()
+
+	current := tusOffset(uploadInfo)
+	if offset != current {
+		c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+		c.String(http.StatusConflict, "Upload-Offset 与服务端不一致，当前偏移量为 %d", current)
+		return
+	}
+
+	// 已完成的上传不再接收数据，客户端丢失最后一个 PATCH 的响应后重发时直接确认已完成
+	uploadInfo.Mu.Lock()
+	finished := uploadInfo.Finished
+	uploadInfo.Mu.Unlock()
+	if finished {
+		c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+		if c.Request.ContentLength != 0 {
+			c.String(http.StatusRequestEntityTooLarge, "%s: 上传已完成", errTusBodyTooLarge.Error())
+			return
+		}
+		c.Header("Upload-Expires", uploadInfo.ExpiresAt.UTC().Format(http.TimeFormat))
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	// 超出剩余长度的数据无处存放，直接拒绝而不是截断
+	if c.Request.ContentLength > uploadInfo.TotalSize-offset {
+		c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+		c.String(http.StatusRequestEntityTooLarge, "%s: 剩余 %d 字节", errTusBodyTooLarge.Error(), uploadInfo.TotalSize-offset)
+		return
+	}
+
+	// 请求体可能没有 Content-Length，按剩余的全部数据检查磁盘空间
+	size := uploadInfo.TotalSize - offset
+	if c.Request.ContentLength >= 0 && c.Request.ContentLength < size {
+		size = c.Request.ContentLength
+	}
+	if err := checkSessionQuota(uploadInfo, size); err != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+		c.String(quotaStatus(err), err.Error())
+		return
+	}
+
+	var body io.Reader = io.LimitReader(c.Request.Body, uploadInfo.TotalSize-offset)
+	if hasher != nil {
+		body = io.TeeReader(body, hasher)
+	}
+
+	newOffset, err := writeTusData(uploadInfo, body, offset)
+	// 没有 Content-Length 的请求体只能在写满剩余长度后检查是否还有多余的数据
+	if err == nil && newOffset == uploadInfo.TotalSize && tusBodyRemains(c.Request.Body) {
+		err = errTusBodyTooLarge
+	}
+	if err == nil && hasher != nil && string(hasher.Sum(nil)) != string(expectedSum) {
+		err = errTusChecksumMismatch
+	}
+
+	if err != nil {
+		// 带校验和或超出剩余长度的请求要么整体写入要么整体丢弃；否则保留已接收的数据供客户端续传
+		if hasher != nil || errors.Is(err, errTusBodyTooLarge) {
+			rollbackTusData(uploadInfo, offset, newOffset)
+			newOffset = offset
+		}
+		if errors.Is(err, errTusChecksumMismatch) {
+			c.String(StatusChecksumMismatch, err.Error())
+			return
+		}
+		if errors.Is(err, errTusBodyTooLarge) {
+			c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+			c.String(http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		c.String(http.StatusInternalServerError, "写入数据失败: %s", err.Error())
+		return
+	}
+
+	uploadInfo.Mu.Lock()
+	uploadInfo.ExpiresAt = time.Now().Add(relayConfig.Upload.TusExpiration)
+	uploadInfo.Mu.Unlock()
+
+	if newOffset == uploadInfo.TotalSize {
+		if err := finishTusUpload(c.Request.Context(), uploadInfo); err != nil {
+			c.String(tusFinishStatus(err), err.Error())
+			return
+		}
+	} else if err := models.SaveUploadInfo(uploadInfo); err != nil {
+		fmt.Printf("保存上传会话 %s 失败: %v\n", uploadInfo.FileID, err)
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	c.Header("Upload-Expires", uploadInfo.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusNoContent)
+}
+
+// TusTerminate 终止上传并删除已接收的数据（termination 扩展）
+func TusTerminate(c *gin.Context) {
+	uploadInfo, ok := getTusUpload(c)
+	if !ok {
+		return
+	}
+
+	uploadInfo.WriteMu.Lock()
+	defer uploadInfo.WriteMu.Unlock()
+
+	removeUploadChunks(uploadInfo)
+	if err := models.RemoveUploadInfo(uploadInfo.FileID); err != nil {
+		c.String(http.StatusInternalServerError, "删除上传会话失败: %s", err.Error())
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// getTusUpload 根据路径参数查找 tus 上传会话，失败时写入响应并返回 false
+func getTusUpload(c *gin.Context) (*models.UploadInfo, bool) {
+	fileID := c.Param("id")
+
+	models.UploadsMutex.Lock()
+	uploadInfo, exists := models.Uploads[fileID]
+	models.UploadsMutex.Unlock()
+
+	if !exists || uploadInfo.Protocol != models.ProtocolTus {
+		c.Status(http.StatusNotFound)
+		return nil, false
+	}
+
+	uploadInfo.Mu.Lock()
+	expired := !uploadInfo.ExpiresAt.IsZero() && time.Now().After(uploadInfo.ExpiresAt)
+	uploadInfo.Mu.Unlock()
+
+	// expiration 扩展：过期的会话返回 410 并清理数据
+	if expired {
+		uploadInfo.WriteMu.Lock()
+		removeUploadChunks(uploadInfo)
+		models.RemoveUploadInfo(fileID)
+		uploadInfo.WriteMu.Unlock()
+		c.Status(http.StatusGone)
+		return nil, false
+	}
+
+	return uploadInfo, true
+}
+
+// tusOffset 计算已连续接收的字节数，调用方需持有 info.WriteMu
+func tusOffset(info *models.UploadInfo) int64 {
+	info.Mu.Lock()
+	finished := info.Finished
+	info.Mu.Unlock()
+	if finished {
+		return info.TotalSize
+	}
+
+	var offset int64
+	for i := 0; i < info.TotalChunks; i++ {
+		info.Mu.Lock()
+		done := info.Completed[i]
+		info.Mu.Unlock()
+
+		if done {
+			offset += info.ChunkLength(i)
+			continue
+		}
+
+		// 第一个未完成的分块可能已写入部分数据
+		chunkPath := filepath.Join(relayConfig.Storage.TempDir, models.ChunkFileName(info.FileID, i))
+		if stat, err := os.Stat(chunkPath); err == nil {
+			offset += stat.Size()
+		}
+		break
+	}
+	return offset
+}
+
+// writeTusData 将数据按分块边界写入分块文件，返回写入后的偏移量
+func writeTusData(info *models.UploadInfo, body io.Reader, offset int64) (int64, error) {
+	for offset < info.TotalSize {
+		chunkIndex := int(offset / info.ChunkSize)
+		remaining := info.ChunkLength(chunkIndex) - (offset - int64(chunkIndex)*info.ChunkSize)
+
+		chunkPath := filepath.Join(relayConfig.Storage.TempDir, models.ChunkFileName(info.FileID, chunkIndex))
+		chunkFile, err := os.OpenFile(chunkPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return offset, err
+		}
+
+		n, err := io.CopyN(chunkFile, body, remaining)
+		chunkFile.Close()
+		offset += n
+
+		if n == remaining {
+			info.Mu.Lock()
+			info.Completed[chunkIndex] = true
+			info.Mu.Unlock()
+		}
+
+		if err == io.EOF {
+			// 请求体已读完
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+	}
+	return offset, nil
+}
+
+// tusBodyRemains 请求体在读完剩余的上传长度后是否还有数据
+func tusBodyRemains(body io.Reader) bool {
+	n, _ := body.Read(make([]byte, 1))
+	return n > 0
+}
+
+// rollbackTusData 丢弃 [from, to) 区间内写入的数据
+func rollbackTusData(info *models.UploadInfo, from, to int64) {
+	if to <= from {
+		return
+	}
+
+	first := int(from / info.ChunkSize)
+	last := int((to - 1) / info.ChunkSize)
+	for i := first; i <= last; i++ {
+		chunkPath := filepath.Join(relayConfig.Storage.TempDir, models.ChunkFileName(info.FileID, i))
+		if i == first {
+			os.Truncate(chunkPath, from-int64(first)*info.ChunkSize)
+		} else {
+			os.Remove(chunkPath)
+		}
+
+		info.Mu.Lock()
+		info.Completed[i] = false
+		info.Mu.Unlock()
+	}
+}
+
+// finishTusUpload 所有数据接收完毕后合并分块、纳入存储并删除分块
+// 会话记录标记为已完成并保留到过期，丢失最后一个 PATCH 响应的客户端仍可通过 HEAD 查询到完整的偏移量；
+// 完整性验证失败或同名文件已存在时数据无法通过续传修复，丢弃会话；保存失败时保留分块，客户端重新发送 PATCH 即可重试
+func finishTusUpload(ctx context.Context, info *models.UploadInfo) error {
+	staged, err := mergeUploadChunks(ctx, info)
+	if err != nil {
+		return err
+	}
+
+	if info.FileHash != "" && staged.digest != info.FileHash {
+		fileStorage.Delete(ctx, staged.key)
+		removeUploadChunks(info)
+		models.RemoveUploadInfo(info.FileID)
+		return fmt.Errorf("%w: 文件完整性验证失败，期望 %s，实际 %s（%s）",
+			errTusChecksumMismatch, info.FileHash, staged.digest, info.HashAlgorithm())
+	}
+
+	// 创建会话之后可能有其他上传写入了同名文件，由 commitObject 在索引锁内检查
+	if _, _, err := commitObject(ctx, staged, info.FileName, info.Owner, info.Overwrite); err != nil {
+		if errors.Is(err, errFileExists) {
+			removeUploadChunks(info)
+			models.RemoveUploadInfo(info.FileID)
+			return err
+		}
+		return fmt.Errorf("保存文件失败: %w", err)
+	}
+
+	removeUploadChunks(info)
+	info.Mu.Lock()
+	info.Finished = true
+	info.Mu.Unlock()
+	// 文件已纳入存储，会话记录保存失败只影响重启后的偏移量查询
+	if err := models.SaveUploadInfo(info); err != nil {
+		fmt.Printf("保存上传会话 %s 失败: %v\n", info.FileID, err)
+	}
+	return nil
+}
+
+// tusFinishStatus 完成上传失败时的状态码：校验失败为 460，同名文件已存在为 409
+func tusFinishStatus(err error) int {
+	switch {
+	case errors.Is(err, errTusChecksumMismatch):
+		return StatusChecksumMismatch
+	case errors.Is(err, errFileExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// removeUploadChunks 删除上传会话的所有分块文件或预分配文件
+func removeUploadChunks(info *models.UploadInfo) {
+	if info.Positional {
+		os.Remove(partFilePath(info))
+		return
+	}
+	for i := 0; i < info.TotalChunks; i++ {
+		os.Remove(filepath.Join(relayConfig.Storage.TempDir, models.ChunkFileName(info.FileID, i)))
+	}
+}
+
+// tusLocation 构建上传资源的 URL
+func tusLocation(c *gin.Context, fileID string) string {
+	return strings.TrimSuffix(c.FullPath(), "/") + "/" + fileID
+}
+
+// parseTusMetadata 解析 Upload-Metadata：以逗号分隔的 "键 Base64值" 对，值可省略
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("键不能为空")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("键 %s 的值不是合法的 Base64", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// encodeTusMetadata 将元数据编码为 Upload-Metadata 格式
+func encodeTusMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(metadata[key])))
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"com.example/relay/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestTusUpload(t *testing.T) {
+	cfg := newTestEnv(t)
+	cfg.Upload.DefaultChunkSize = 2
+	if err := os.MkdirAll(cfg.Storage.TempDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	SetupTusRoutes(router.Group("/file/tus"))
+
+	do := func(method, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Tus-Resumable", tusVersion)
+		if method == http.MethodPatch {
+			req.Header.Set("Content-Type", "application/offset+octet-stream")
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/file/tus", "", map[string]string{
+		"Upload-Length":   "5",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("tus.txt")),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("创建会话状态码 = %d，响应 %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+
+	md5sum := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return "md5 " + base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	// 按顺序执行，每一步之后检查状态码和 Upload-Offset
+	steps := []struct {
+		name     string
+		method   string
+		offset   string
+		body     string
+		checksum string
+		status   int
+		want     string // 期望的 Upload-Offset，为空表示不检查
+	}{
+		{"跨分块写入部分数据", http.MethodPatch, "0", "hel", "", http.StatusNoContent, "3"},
+		{"查询偏移量", http.MethodHead, "", "", "", http.StatusOK, "3"},
+		{"偏移量不一致", http.MethodPatch, "0", "x", "", http.StatusConflict, "3"},
+		{"校验和不匹配时整体丢弃", http.MethodPatch, "3", "lo", md5sum("xx"), StatusChecksumMismatch, ""},
+		{"校验失败后偏移量不变", http.MethodHead, "", "", "", http.StatusOK, "3"},
+		{"超出剩余长度", http.MethodPatch, "3", "lo!!", "", http.StatusRequestEntityTooLarge, "3"},
+		{"校验和匹配并完成上传", http.MethodPatch, "3", "lo", md5sum("lo"), http.StatusNoContent, "5"},
+		{"完成后仍可查询偏移量", http.MethodHead, "", "", "", http.StatusOK, "5"},
+		{"完成后重发空 PATCH", http.MethodPatch, "5", "", "", http.StatusNoContent, "5"},
+		{"完成后不再接收数据", http.MethodPatch, "5", "x", "", http.StatusRequestEntityTooLarge, "5"},
+		{"完成后重发最后一个 PATCH", http.MethodPatch, "3", "lo", "", http.StatusConflict, "5"},
+		{"终止会话", http.MethodDelete, "", "", "", http.StatusNoContent, ""},
+		{"终止后会话不存在", http.MethodHead, "", "", "", http.StatusNotFound, ""},
+	}
+
+	for _, step := range steps {
+		headers := map[string]string{}
+		if step.offset != "" {
+			headers["Upload-Offset"] = step.offset
+		}
+		if step.checksum != "" {
+			headers["Upload-Checksum"] = step.checksum
+		}
+		w := do(step.method, location, step.body, headers)
+		if w.Code != step.status {
+			t.Fatalf("%s: 状态码 = %d，期望 %d，响应 %s", step.name, w.Code, step.status, w.Body.String())
+		}
+		if got := w.Header().Get("Upload-Offset"); step.want != "" && got != step.want {
+			t.Fatalf("%s: Upload-Offset = %q，期望 %q", step.name, got, step.want)
+		}
+		if step.method == http.MethodHead && step.status == http.StatusOK && w.Header().Get("Upload-Length") != "5" {
+			t.Fatalf("%s: Upload-Length = %q", step.name, w.Header().Get("Upload-Length"))
+		}
+	}
+
+	meta, exists, err := models.GetFile("tus.txt")
+	if err != nil || !exists {
+		t.Fatalf("文件索引不存在: %v", err)
+	}
+	reader, err := fileStorage.Get(context.Background(), blobKey(meta.Hash), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); string(data) != "hello" {
+		t.Fatalf("文件内容 = %q", data)
+	}
+}
@@ -1,24 +1,80 @@
 package main
 
 import (
+	"net/http"
+	"os"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
 	"com.example/relay/config"
 	"com.example/relay/handlers"
+	"com.example/relay/models"
+	"com.example/relay/storage"
+	"com.example/relay/store"
 )
 
 func main() {
+	// 加载配置：默认值 < 配置文件 < 环境变量 < 命令行参数
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+
 	// 初始化配置
-	config.Init()
+	if err := cfg.Init(); err != nil {
+		panic(err)
+	}
+
+	// 打开嵌入式数据库
+	if err := store.Init(cfg.Storage.DBPath); err != nil {
+		panic(err)
+	}
+	defer store.Close()
+
+	// 恢复重启前未完成的上传会话
+	if err := models.LoadUploads(cfg.Storage.TempDir); err != nil {
+		panic(err)
+	}
+
+	// 统计各节点的存储用量，用于检查配额
+	if err := models.LoadUsage(); err != nil {
+		panic(err)
+	}
+
+	// 统计各节点的待投递消息数，补齐消息索引
+	if err := models.LoadOutbox(); err != nil {
+		panic(err)
+	}
+
+	// 创建存储后端
+	fileStorage, err := storage.New(&cfg.Storage)
+	if err != nil {
+		panic(err)
+	}
+
+	// 向处理器注入配置和存储后端
+	handlers.Init(cfg, fileStorage)
 
 	router := gin.Default()
 
 	// cors
-	router.Use(cors.Default())
+	corsConfig := cors.DefaultConfig()
+	if cfg.CORS.AllowAllOrigins() {
+		corsConfig.AllowAllOrigins = true
+	} else {
+		corsConfig.AllowOrigins = cfg.CORS.AllowOrigins
+	}
+	corsConfig.AddAllowHeaders(handlers.TusHeaders...)
+	corsConfig.AddExposeHeaders(handlers.TusHeaders...)
+	corsConfig.AddExposeHeaders(handlers.ChunkHeaders...)
+	router.Use(cors.New(corsConfig))
 
 	// 增加最大请求体大小限制
-	router.MaxMultipartMemory = 8 << 20 // 8 MiB
+	router.MaxMultipartMemory = cfg.Server.MaxMultipartMemory
+
+	// 按原始路径匹配路由，文件名中编码为 %2F 的 / 不会被当作路径分隔符
+	router.UseRawPath = true
 
 	router.GET("/", func(c *gin.Context) {
 		c.String(200, "啥也没有😅!")
@@ -40,7 +96,25 @@ func main() {
 	syncRouter := router.Group("/sync")
 	handlers.SetupSyncRoutes(syncRouter)
 
-	err := router.Run(":8080")
+	// 后台清理相关路由
+	janitorRouter := router.Group("/janitor")
+	handlers.SetupJanitorRoutes(janitorRouter)
+
+	// 定期清理过期会话和孤立临时文件
+	handlers.StartJanitor()
+
+	// 重发超时未确认的节点消息
+	handlers.StartOutboxRetry()
+
+	server := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+
+	err = server.ListenAndServe()
 
 	if err != nil {
 		panic(err)
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"com.example/relay/store"
+	"com.example/relay/utils"
+)
+
+// nodeSecretsBucket 节点凭证在数据库中的桶名，与节点信息分开存放，避免随节点信息对外返回
+const nodeSecretsBucket = "node_secrets"
+
+// CreateNode 保存新注册的节点并签发共享密钥，两者在同一个事务中写入，不会留下没有凭证的节点
+func CreateNode(node *NodeInfo) (string, error) {
+	secret, err := utils.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	err = store.Update(func(tx *store.Tx) error {
+		if err := tx.Put(nodesBucket, node.UID, node); err != nil {
+			return err
+		}
+		return tx.Put(nodeSecretsBucket, node.UID, secret)
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// GetNodeSecret 获取节点的共享密钥
+func GetNodeSecret(uid string) (string, bool, error) {
+	var secret string
+	exists, err := store.Get(nodeSecretsBucket, uid, &secret)
+	return secret, exists, err
+}
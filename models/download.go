@@ -3,19 +3,25 @@ package models
 import (
 	"sync"
 	"time"
+
+	"com.example/relay/utils"
 )
 
 // DownloadInfo 下载信息结构体
 type DownloadInfo struct {
-	FileID      string         // 文件唯一标识
-	FileName    string         // 文件名
-	FilePath    string         // 文件路径
-	TotalSize   int64          // 文件总大小
-	ChunkSize   int64          // 每个块的大小
-	TotalChunks int            // 总块数
-	CreatedAt   time.Time      // 创建时间
-	FileHash    string         // 文件的哈希值
-	ChunkHashes map[int]string // 分块哈希值映射
+	FileID      string            // 文件唯一标识
+	FileName    string            // 文件名
+	FileKey     string            // 文件在存储中的对象键
+	BlobHash    string            // 文件内容所在 blob 的哈希值，会话存在期间该 blob 不会被删除；未纳入索引的旧文件为空
+	TotalSize   int64             // 文件总大小
+	ChunkSize   int64             // 每个块的大小
+	TotalChunks int               // 总块数
+	CreatedAt   time.Time         // 创建时间
+	AccessedAt  time.Time         // 最近一次读取分块的时间，受 Mu 保护
+	HashAlgo    string            // 哈希值使用的哈希算法
+	FileHash    string            // 文件的哈希值
+	ChunkHashes map[int]string    // 分块哈希值映射
+	Merkle      *utils.MerkleTree // 由分块哈希值构建的 Merkle 树，分块哈希值全部就绪后生成
 	Mu          sync.Mutex
 }
 
@@ -40,23 +46,62 @@ func SaveDownloadInfo(info *DownloadInfo) {
 	Downloads[info.FileID] = info
 }
 
-// RemoveDownloadInfo 删除下载信息
-func RemoveDownloadInfo(fileID string) {
+// RemoveDownloadInfo 删除下载信息，返回被删除的会话，不存在时返回 nil
+func RemoveDownloadInfo(fileID string) *DownloadInfo {
 	DownloadsMutex.Lock()
 	defer DownloadsMutex.Unlock()
 
+	info := Downloads[fileID]
 	delete(Downloads, fileID)
+	return info
+}
+
+// BlobInDownload 是否有下载会话正在读取该 blob
+func BlobInDownload(hash string) bool {
+	DownloadsMutex.Lock()
+	defer DownloadsMutex.Unlock()
+
+	for _, info := range Downloads {
+		if info.BlobHash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// FindDownloadsByFile 查找引用该文件的下载会话，返回会话标识
+func FindDownloadsByFile(fileName string) []string {
+	DownloadsMutex.Lock()
+	defer DownloadsMutex.Unlock()
+
+	ids := []string{}
+	for id, info := range Downloads {
+		if info.FileName == fileName {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
-// CleanupExpiredDownloads 清理过期的下载信息
-func CleanupExpiredDownloads(maxAge time.Duration) {
+// CleanupExpiredDownloads 清理超过 maxAge 未被访问的下载信息，返回被清理的会话
+func CleanupExpiredDownloads(maxAge time.Duration) []*DownloadInfo {
 	DownloadsMutex.Lock()
 	defer DownloadsMutex.Unlock()
 
 	now := time.Now()
+	removed := []*DownloadInfo{}
 	for id, info := range Downloads {
-		if now.Sub(info.CreatedAt) > maxAge {
+		info.Mu.Lock()
+		lastActive := info.AccessedAt
+		info.Mu.Unlock()
+		if lastActive.IsZero() {
+			lastActive = info.CreatedAt
+		}
+
+		if now.Sub(lastActive) > maxAge {
 			delete(Downloads, id)
+			removed = append(removed, info)
 		}
 	}
+	return removed
 }
@@ -0,0 +1,247 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"com.example/relay/store"
+)
+
+// 文件索引与内容存储在数据库中的桶名
+const (
+	filesBucket = "files"
+	blobsBucket = "blobs"
+)
+
+// FileMeta 文件名索引，记录文件名到内容哈希的映射
+type FileMeta struct {
+	Name       string    `json:"name"`                // 文件名（相对存储根目录的路径）
+	Hash       string    `json:"hash"`                // 内容哈希，对应的 blob 键
+	Size       int64     `json:"size"`                // 文件大小
+	MimeType   string    `json:"mime_type,omitempty"` // 根据文件内容识别的 MIME 类型
+	Owner      string    `json:"owner,omitempty"`     // 上传者（节点ID或客户端标识）
+	CreatedAt  time.Time `json:"created_at"`          // 创建时间
+	ModifiedAt time.Time `json:"modified_at"`         // 最近一次修改时间
+}
+
+// BlobInfo 内容寻址存储中的一个数据块
+type BlobInfo struct {
+	Hash      string    `json:"hash"`                // 内容哈希
+	Size      int64     `json:"size"`                // 数据大小
+	MimeType  string    `json:"mime_type,omitempty"` // 根据内容识别的 MIME 类型
+	RefCount  int       `json:"ref_count"`           // 引用该内容的文件名数量，归零后可删除
+	CreatedAt time.Time `json:"created_at"`          // 首次写入时间
+}
+
+// ErrFileNotFound 文件索引不存在
+var ErrFileNotFound = errors.New("文件不存在")
+
+// FilesMutex 保护文件索引与引用计数的读改写操作，以及 blob 文件的写入和删除
+var FilesMutex sync.Mutex
+
+// GetFile 获取文件索引
+func GetFile(name string) (*FileMeta, bool, error) {
+	var meta FileMeta
+	exists, err := store.Get(filesBucket, name, &meta)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	return &meta, true, nil
+}
+
+// ListFiles 按文件名顺序列出名称以 prefix 开头的文件索引
+func ListFiles(prefix string) ([]*FileMeta, error) {
+	files := []*FileMeta{}
+	err := store.ForEachPrefix(filesBucket, prefix, func(key string, data []byte) error {
+		var meta FileMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			fmt.Printf("解析文件索引 %s 失败: %v\n", key, err)
+			return nil
+		}
+		files = append(files, &meta)
+		return nil
+	})
+	return files, err
+}
+
+// GetBlob 获取 blob 信息
+func GetBlob(hash string) (*BlobInfo, bool, error) {
+	var blob BlobInfo
+	exists, err := store.Get(blobsBucket, hash, &blob)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	return &blob, true, nil
+}
+
+// LinkFile 建立文件名到 blob 的索引并增加引用计数，调用方需持有 FilesMutex
+// 若文件名原先指向其他 blob，则减少其引用计数；返回引用计数归零、可以删除的 blob 哈希
+// 索引和引用计数在同一个事务中更新，中途失败时不会留下引用计数与索引不一致的记录
+func LinkFile(meta *FileMeta) (string, error) {
+	var old *FileMeta
+	var orphan string
+	err := store.Update(func(tx *store.Tx) error {
+		var err error
+		old, orphan, err = linkFile(tx, meta)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if old != nil {
+		addUsage(old.Owner, -1, -old.Size)
+	}
+	addUsage(meta.Owner, 1, meta.Size)
+	return orphan, nil
+}
+
+// linkFile 在事务中建立文件名索引，返回被替换的原索引和引用计数归零的 blob 哈希
+func linkFile(tx *store.Tx, meta *FileMeta) (*FileMeta, string, error) {
+	old, exists, err := getFile(tx, meta.Name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 同名且内容相同，仅更新元数据
+	if exists && old.Hash == meta.Hash {
+		meta.CreatedAt = old.CreatedAt
+		if meta.MimeType == "" {
+			meta.MimeType = old.MimeType
+		}
+		return old, "", tx.Put(filesBucket, meta.Name, meta)
+	}
+
+	var blob BlobInfo
+	blobExists, err := tx.Get(blobsBucket, meta.Hash, &blob)
+	if err != nil {
+		return nil, "", err
+	}
+	if !blobExists {
+		blob = BlobInfo{
+			Hash:      meta.Hash,
+			Size:      meta.Size,
+			MimeType:  meta.MimeType,
+			CreatedAt: time.Now(),
+		}
+	}
+	if meta.MimeType == "" {
+		meta.MimeType = blob.MimeType
+	}
+	blob.RefCount++
+	if err := tx.Put(blobsBucket, blob.Hash, &blob); err != nil {
+		return nil, "", err
+	}
+
+	if exists {
+		meta.CreatedAt = old.CreatedAt
+	}
+	if err := tx.Put(filesBucket, meta.Name, meta); err != nil {
+		return nil, "", err
+	}
+
+	if !exists {
+		return nil, "", nil
+	}
+	orphan, err := releaseBlob(tx, old.Hash)
+	return old, orphan, err
+}
+
+// UnlinkFile 删除文件名索引并减少引用计数，调用方需持有 FilesMutex
+// 返回引用计数归零、可以删除的 blob 哈希
+func UnlinkFile(name string) (string, bool, error) {
+	var meta *FileMeta
+	var orphan string
+	err := store.Update(func(tx *store.Tx) error {
+		var err error
+		meta, orphan, err = unlinkFile(tx, name)
+		return err
+	})
+	if err != nil || meta == nil {
+		return "", meta != nil, err
+	}
+
+	addUsage(meta.Owner, -1, -meta.Size)
+	return orphan, true, nil
+}
+
+// unlinkFile 在事务中删除文件名索引，返回被删除的索引（不存在时为 nil）和引用计数归零的 blob 哈希
+func unlinkFile(tx *store.Tx, name string) (*FileMeta, string, error) {
+	meta, exists, err := getFile(tx, name)
+	if err != nil || !exists {
+		return nil, "", err
+	}
+
+	if err := tx.Delete(filesBucket, name); err != nil {
+		return nil, "", err
+	}
+	orphan, err := releaseBlob(tx, meta.Hash)
+	return meta, orphan, err
+}
+
+// RenameFile 将文件索引从 from 移动到 to，调用方需持有 FilesMutex
+// to 已存在时会被替换并减少其原内容的引用计数；返回引用计数归零、可以删除的 blob 哈希
+func RenameFile(from, to string) (string, error) {
+	var replaced *FileMeta
+	var orphan string
+	err := store.Update(func(tx *store.Tx) error {
+		meta, exists, err := getFile(tx, from)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrFileNotFound
+		}
+		if from == to {
+			return nil
+		}
+
+		replaced, orphan, err = unlinkFile(tx, to)
+		if err != nil {
+			return err
+		}
+
+		meta.Name = to
+		meta.ModifiedAt = time.Now()
+		if err := tx.Put(filesBucket, to, meta); err != nil {
+			return err
+		}
+		return tx.Delete(filesBucket, from)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if replaced != nil {
+		addUsage(replaced.Owner, -1, -replaced.Size)
+	}
+	return orphan, nil
+}
+
+// getFile 在事务中获取文件索引
+func getFile(tx *store.Tx, name string) (*FileMeta, bool, error) {
+	var meta FileMeta
+	exists, err := tx.Get(filesBucket, name, &meta)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	return &meta, true, nil
+}
+
+// releaseBlob 在事务中减少 blob 的引用计数，归零时删除记录并返回其哈希
+func releaseBlob(tx *store.Tx, hash string) (string, error) {
+	var blob BlobInfo
+	exists, err := tx.Get(blobsBucket, hash, &blob)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	blob.RefCount--
+	if blob.RefCount > 0 {
+		return "", tx.Put(blobsBucket, hash, &blob)
+	}
+	return hash, tx.Delete(blobsBucket, hash)
+}
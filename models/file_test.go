@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"com.example/relay/store"
+)
+
+// initTestStore 在临时目录中打开数据库，测试结束后关闭
+func initTestStore(t *testing.T) {
+	t.Helper()
+	if err := store.Init(filepath.Join(t.TempDir(), "relay.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+}
+
+func TestFileIndexRefCount(t *testing.T) {
+	initTestStore(t)
+	if err := LoadUsage(); err != nil {
+		t.Fatal(err)
+	}
+
+	link := func(name, hash string) func() (string, error) {
+		return func() (string, error) {
+			return LinkFile(&FileMeta{Name: name, Hash: hash, Size: 10, Owner: "n1"})
+		}
+	}
+	unlink := func(name string) func() (string, error) {
+		return func() (string, error) {
+			orphan, _, err := UnlinkFile(name)
+			return orphan, err
+		}
+	}
+	rename := func(from, to string) func() (string, error) {
+		return func() (string, error) {
+			return RenameFile(from, to)
+		}
+	}
+
+	// 按顺序执行，每一步之后检查返回的孤立 blob、各 blob 的引用计数和文件索引
+	steps := []struct {
+		name   string
+		op     func() (string, error)
+		orphan string
+		refs   map[string]int // 0 表示 blob 记录已删除
+		files  map[string]string
+	}{
+		{"新文件", link("a", "h1"), "", map[string]int{"h1": 1}, map[string]string{"a": "h1"}},
+		{"相同内容的另一个文件", link("b", "h1"), "", map[string]int{"h1": 2}, map[string]string{"a": "h1", "b": "h1"}},
+		{"同名同内容只更新元数据", link("b", "h1"), "", map[string]int{"h1": 2}, map[string]string{"b": "h1"}},
+		{"覆盖为新内容", link("b", "h2"), "", map[string]int{"h1": 1, "h2": 1}, map[string]string{"b": "h2"}},
+		{"重命名到不存在的文件名", rename("a", "c"), "", map[string]int{"h1": 1}, map[string]string{"a": "", "c": "h1"}},
+		{"重命名替换另一个文件", rename("c", "b"), "h2", map[string]int{"h1": 1, "h2": 0}, map[string]string{"b": "h1", "c": ""}},
+		{"重命名为自身", rename("b", "b"), "", map[string]int{"h1": 1}, map[string]string{"b": "h1"}},
+		{"删除不存在的文件", unlink("x"), "", map[string]int{"h1": 1}, nil},
+		{"删除最后一个引用", unlink("b"), "h1", map[string]int{"h1": 0}, map[string]string{"b": ""}},
+	}
+
+	for _, step := range steps {
+		orphan, err := step.op()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if orphan != step.orphan {
+			t.Fatalf("%s: 孤立 blob = %q，期望 %q", step.name, orphan, step.orphan)
+		}
+		for hash, want := range step.refs {
+			blob, exists, err := GetBlob(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := 0
+			if exists {
+				got = blob.RefCount
+			}
+			if got != want {
+				t.Fatalf("%s: %s 的引用计数 = %d，期望 %d", step.name, hash, got, want)
+			}
+		}
+		for name, want := range step.files {
+			meta, exists, err := GetFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if exists {
+				got = meta.Hash
+			}
+			if got != want {
+				t.Fatalf("%s: %s 指向 %q，期望 %q", step.name, name, got, want)
+			}
+		}
+	}
+
+	if usage := OwnerUsage("n1"); usage.Files != 0 || usage.Bytes != 0 {
+		t.Fatalf("全部删除后用量 = %+v", usage)
+	}
+	if _, err := RenameFile("missing", "y"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("重命名不存在的文件: %v", err)
+	}
+}
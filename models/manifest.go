@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+
+	"com.example/relay/store"
+	"com.example/relay/utils"
+)
+
+// manifestsBucket 哈希清单在数据库中的桶名
+const manifestsBucket = "manifests"
+
+// HashManifest 文件的哈希清单，记录整个文件的哈希值以及若干分块大小下每个分块的哈希值
+// 纳入内容寻址存储的文件以内容哈希为键，内容不变清单就不会失效；
+// 旧文件以文件名为键，通过大小和修改时间判断清单是否仍然有效；每种哈希算法各有一份清单
+type HashManifest struct {
+	Key         string             `json:"key"`          // 清单键
+	Algo        string             `json:"algo"`         // 哈希算法，早期版本保存的清单为空，即MD5
+	FileHash    string             `json:"file_hash"`    // 整个文件的哈希值
+	Size        int64              `json:"size"`         // 文件大小
+	ModTime     time.Time          `json:"mod_time"`     // 计算清单时文件的修改时间
+	ChunkHashes map[int64][]string `json:"chunk_hashes"` // 分块大小 -> 按顺序排列的分块哈希值
+	CreatedAt   time.Time          `json:"created_at"`   // 创建时间
+}
+
+// BlobManifestKey 内容寻址存储中的文件在指定哈希算法下的清单键
+func BlobManifestKey(hash, algo string) string {
+	return manifestKey("blob:"+hash, algo)
+}
+
+// LegacyManifestKey 未纳入索引的旧文件在指定哈希算法下的清单键
+func LegacyManifestKey(name, algo string) string {
+	return manifestKey("legacy:"+name, algo)
+}
+
+// manifestKey 默认算法的清单沿用早期版本不带算法的键，其他算法以算法名为前缀
+func manifestKey(key, algo string) string {
+	if algo == utils.DefaultHashAlgo {
+		return key
+	}
+	return algo + ":" + key
+}
+
+// GetManifest 获取哈希清单
+func GetManifest(key string) (*HashManifest, bool, error) {
+	var manifest HashManifest
+	exists, err := store.Get(manifestsBucket, key, &manifest)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	return &manifest, true, nil
+}
+
+// SaveManifest 保存哈希清单
+func SaveManifest(manifest *HashManifest) error {
+	return store.Put(manifestsBucket, manifest.Key, manifest)
+}
+
+// RemoveManifest 删除哈希清单
+func RemoveManifest(key string) error {
+	return store.Delete(manifestsBucket, key)
+}
+
+// RemoveBlobManifests 删除内容寻址存储中的文件在所有哈希算法下的清单
+func RemoveBlobManifests(hash string) error {
+	return removeManifests(func(algo string) string { return BlobManifestKey(hash, algo) })
+}
+
+// RemoveLegacyManifests 删除旧文件在所有哈希算法下的清单
+func RemoveLegacyManifests(name string) error {
+	return removeManifests(func(algo string) string { return LegacyManifestKey(name, algo) })
+}
+
+// removeManifests 依次删除每种哈希算法下的清单，返回遇到的第一个错误
+func removeManifests(key func(algo string) string) error {
+	var firstErr error
+	for _, algo := range utils.HashAlgorithms() {
+		if err := RemoveManifest(key(algo)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ChunkHashMap 将指定分块大小的哈希值转换为下载会话使用的 索引 -> 哈希 映射
+func (m *HashManifest) ChunkHashMap(chunkSize int64) (map[int]string, bool) {
+	hashes, ok := m.ChunkHashes[chunkSize]
+	if !ok {
+		return nil, false
+	}
+	chunkHashes := make(map[int]string, len(hashes))
+	for i, hash := range hashes {
+		chunkHashes[i] = hash
+	}
+	return chunkHashes, true
+}
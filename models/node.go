@@ -0,0 +1,149 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"com.example/relay/store"
+)
+
+// nodesBucket 节点注册表在数据库中的桶名
+const nodesBucket = "nodes"
+
+// NodeState 节点生命周期状态
+type NodeState string
+
+const (
+	NodeRegistered   NodeState = "registered"   // 已注册，尚未初始化
+	NodeInitializing NodeState = "initializing" // 已下发初始化指令，等待节点确认
+	NodeInitialized  NodeState = "initialized"  // 初始化完成
+	NodeInitFailed   NodeState = "init_failed"  // 初始化失败，可重新初始化
+)
+
+// nodeTransitions 允许的状态迁移
+// 节点可能在回复初始化结果之前断开，initializing 状态允许重新下发初始化指令
+var nodeTransitions = map[NodeState][]NodeState{
+	NodeRegistered:   {NodeInitializing},
+	NodeInitializing: {NodeInitializing, NodeInitialized, NodeInitFailed},
+	NodeInitialized:  {NodeInitializing},
+	NodeInitFailed:   {NodeInitializing},
+}
+
+// NodeInfo 节点注册信息
+type NodeInfo struct {
+	UID            string            `json:"uid"`                    // 节点唯一标识
+	Name           string            `json:"name"`                   // 节点名称
+	Version        string            `json:"version"`                // 节点软件版本
+	Labels         map[string]string `json:"labels,omitempty"`       // 节点标签
+	Capabilities   []string          `json:"capabilities,omitempty"` // 节点能力列表
+	State          NodeState         `json:"state"`                  // 生命周期状态
+	Status         string            `json:"status,omitempty"`       // 节点最近一次上报的运行状态
+	RegisteredAt   time.Time         `json:"registered_at"`          // 注册时间
+	LastSeenAt     time.Time         `json:"last_seen_at"`           // 最近一次上报时间
+	ConnectedAt    time.Time         `json:"connected_at"`           // 最近一次上线（建立第一个 WebSocket 连接）的时间
+	DisconnectedAt time.Time         `json:"disconnected_at"`        // 最近一次离线（最后一个 WebSocket 连接断开）的时间
+	UpdatedAt      time.Time         `json:"updated_at"`             // 状态更新时间
+}
+
+var (
+	// ErrNodeNotFound 节点未注册
+	ErrNodeNotFound = errors.New("节点不存在")
+	// ErrInvalidTransition 非法的状态迁移
+	ErrInvalidTransition = errors.New("非法的节点状态迁移")
+)
+
+// NodesMutex 保护节点注册表的读改写操作
+var NodesMutex sync.Mutex
+
+// GetNode 获取节点信息
+func GetNode(uid string) (*NodeInfo, bool, error) {
+	var node NodeInfo
+	exists, err := store.Get(nodesBucket, uid, &node)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	return &node, true, nil
+}
+
+// SaveNode 保存节点信息
+func SaveNode(node *NodeInfo) error {
+	return store.Put(nodesBucket, node.UID, node)
+}
+
+// ListNodes 列出所有已注册节点，按注册时间排序
+func ListNodes() ([]*NodeInfo, error) {
+	nodes := []*NodeInfo{}
+	err := store.ForEach(nodesBucket, func(key string, data []byte) error {
+		var node NodeInfo
+		if err := json.Unmarshal(data, &node); err != nil {
+			return fmt.Errorf("解析节点 %s 失败: %w", key, err)
+		}
+		nodes = append(nodes, &node)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].RegisteredAt.Before(nodes[j].RegisteredAt)
+	})
+	return nodes, nil
+}
+
+// TransitionNode 将节点迁移到新的生命周期状态
+func TransitionNode(uid string, to NodeState) (*NodeInfo, error) {
+	NodesMutex.Lock()
+	defer NodesMutex.Unlock()
+
+	node, exists, err := GetNode(uid)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrNodeNotFound, uid)
+	}
+
+	if !canTransition(node.State, to) {
+		return node, fmt.Errorf("%w: %s 无法从 %s 迁移到 %s", ErrInvalidTransition, uid, node.State, to)
+	}
+
+	node.State = to
+	node.UpdatedAt = time.Now()
+	if err := SaveNode(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// SetNodePresence 记录节点上线或离线的时间，节点未注册时忽略
+func SetNodePresence(uid string, online bool, at time.Time) error {
+	NodesMutex.Lock()
+	defer NodesMutex.Unlock()
+
+	node, exists, err := GetNode(uid)
+	if err != nil || !exists {
+		return err
+	}
+
+	if online {
+		node.ConnectedAt = at
+	} else {
+		node.DisconnectedAt = at
+	}
+	return SaveNode(node)
+}
+
+// canTransition 检查状态迁移是否合法
+func canTransition(from, to NodeState) bool {
+	for _, s := range nodeTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,547 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"com.example/relay/store"
+)
+
+// 消息投递记录在数据库中的桶名
+const (
+	// outboxBucket 尚未确认的消息，键为 <uid>/<序号>，同一节点的消息按写入顺序排列
+	outboxBucket = "outbox"
+	// deliveriesBucket 已结束投递的消息，键为消息标识，保留一段时间供发送方查询
+	deliveriesBucket = "deliveries"
+	// outboxIDsBucket 尚未结束投递的消息的标识索引，键为消息标识，值为消息键
+	outboxIDsBucket = "outbox_ids"
+	// outboxDueBucket 已发送、等待确认的消息按重发时间排列的索引，键为 <重发时间>/<消息键>，值为消息键
+	outboxDueBucket = "outbox_due"
+	// deliveriesDoneBucket 已结束投递的消息按结束时间排列的索引，键为 <结束时间>/<消息标识>，值为消息标识
+	deliveriesDoneBucket = "deliveries_done"
+)
+
+// DeliveryStatus 消息的投递状态
+type DeliveryStatus string
+
+const (
+	DeliveryPending DeliveryStatus = "pending" // 等待发送，节点离线时等重新连接
+	DeliverySent    DeliveryStatus = "sent"    // 已发送，等待节点确认
+	DeliveryAcked   DeliveryStatus = "acked"   // 节点已确认处理
+	DeliveryNacked  DeliveryStatus = "nacked"  // 节点拒绝处理
+	DeliveryFailed  DeliveryStatus = "failed"  // 重试次数用尽仍未确认
+	DeliveryExpired DeliveryStatus = "expired" // 过期前未能送达
+)
+
+// OutboxMessage 发送给节点、需要节点确认的消息
+// 节点离线期间消息保存在待投递队列中，重新连接后按优先级和写入顺序投递；发送后未确认的消息按退避间隔重发
+type OutboxMessage struct {
+	ID          string          `json:"id"`              // 消息唯一标识
+	UID         string          `json:"uid"`             // 目标节点
+	Seq         int64           `json:"seq"`             // 写入序号，单调递增
+	Priority    int             `json:"priority"`        // 优先级，数值越大越先投递
+	Data        json.RawMessage `json:"data"`            // 发送给节点的原始消息
+	Status      DeliveryStatus  `json:"status"`          // 投递状态
+	Attempts    int             `json:"attempts"`        // 已发送的次数
+	Error       string          `json:"error,omitempty"` // 节点拒绝的原因或投递失败的原因
+	CreatedAt   time.Time       `json:"created_at"`      // 写入时间
+	ExpiresAt   time.Time       `json:"expires_at"`      // 过期时间，过期的消息不再投递
+	SentAt      time.Time       `json:"sent_at"`         // 最近一次发送的时间
+	NextRetryAt time.Time       `json:"next_retry_at"`   // 未确认时下一次重发的时间
+	DoneAt      time.Time       `json:"done_at"`         // 投递结束（确认、拒绝、失败或过期）的时间
+}
+
+// Expired 消息是否已过期
+func (m *OutboxMessage) Expired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && now.After(m.ExpiresAt)
+}
+
+// key 消息在数据库中的键
+func (m *OutboxMessage) key() string {
+	return outboxKey(m.UID, m.Seq)
+}
+
+// outboxKey 序号补齐到固定长度，按键排序即按写入顺序排序
+func outboxKey(uid string, seq int64) string {
+	return fmt.Sprintf("%s/%020d", uid, seq)
+}
+
+// indexTime 时间补齐到固定长度，按键排序即按时间排序
+func indexTime(t time.Time) string {
+	return fmt.Sprintf("%020d", max(t.UnixNano(), 0))
+}
+
+// dueKey 消息在重发时间索引中的键
+func (m *OutboxMessage) dueKey() string {
+	return indexTime(m.NextRetryAt) + "/" + m.key()
+}
+
+// doneKey 消息在结束时间索引中的键
+func (m *OutboxMessage) doneKey() string {
+	return indexTime(m.DoneAt) + "/" + m.ID
+}
+
+var (
+	// OutboxMutex 保护待投递消息的读改写操作，以及同一节点消息的投递顺序
+	OutboxMutex sync.Mutex
+
+	// lastOutboxSeq 最近一次分配的写入序号
+	lastOutboxSeq int64
+
+	// outboxCounts 各节点尚未结束投递的消息数，由 OutboxMutex 保护
+	outboxCounts = make(map[string]int)
+)
+
+// nextOutboxSeq 分配写入序号：取当前纳秒时间戳，保证重启后仍然递增，调用方需持有 OutboxMutex
+func nextOutboxSeq() int64 {
+	seq := time.Now().UnixNano()
+	if seq <= lastOutboxSeq {
+		seq = lastOutboxSeq + 1
+	}
+	lastOutboxSeq = seq
+	return seq
+}
+
+// LoadOutbox 扫描待投递队列和投递记录，统计各节点的待投递消息数，并补齐旧版本写入时没有的索引
+func LoadOutbox() error {
+	OutboxMutex.Lock()
+	defer OutboxMutex.Unlock()
+
+	var pending, done []*OutboxMessage
+	var broken, brokenDone []string
+	err := store.ForEach(outboxBucket, func(key string, data []byte) error {
+		var msg OutboxMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			fmt.Printf("解析待投递消息 %s 失败: %v\n", key, err)
+			broken = append(broken, key)
+		} else {
+			pending = append(pending, &msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = store.ForEach(deliveriesBucket, func(key string, data []byte) error {
+		var msg OutboxMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			brokenDone = append(brokenDone, key)
+		} else {
+			done = append(done, &msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	brokenIDs, brokenDue, err := brokenOutboxIndex(broken)
+	if err != nil {
+		return err
+	}
+
+	err = store.Update(func(tx *store.Tx) error {
+		if err := removeBrokenOutbox(tx, broken, brokenIDs, brokenDue); err != nil {
+			return err
+		}
+		for _, msg := range pending {
+			if err := tx.Put(outboxIDsBucket, msg.ID, msg.key()); err != nil {
+				return err
+			}
+			if msg.Status == DeliverySent {
+				if err := tx.Put(outboxDueBucket, msg.dueKey(), msg.key()); err != nil {
+					return err
+				}
+			}
+		}
+		for _, key := range brokenDone {
+			if err := tx.Delete(deliveriesBucket, key); err != nil {
+				return err
+			}
+		}
+		for _, msg := range done {
+			if err := tx.Put(deliveriesDoneBucket, msg.doneKey(), msg.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, msg := range pending {
+		counts[msg.UID]++
+	}
+	outboxCounts = counts
+	return nil
+}
+
+// putOutbox 在事务中写入尚未结束投递的消息，同时更新标识索引和重发时间索引
+func putOutbox(tx *store.Tx, msg *OutboxMessage) error {
+	if _, err := dropDueIndex(tx, msg.key()); err != nil {
+		return err
+	}
+	if err := tx.Put(outboxBucket, msg.key(), msg); err != nil {
+		return err
+	}
+	if err := tx.Put(outboxIDsBucket, msg.ID, msg.key()); err != nil {
+		return err
+	}
+	if msg.Status == DeliverySent {
+		return tx.Put(outboxDueBucket, msg.dueKey(), msg.key())
+	}
+	return nil
+}
+
+// removeOutbox 在事务中从待投递队列中删除消息及其索引，返回消息是否存在
+func removeOutbox(tx *store.Tx, msg *OutboxMessage) (bool, error) {
+	exists, err := dropDueIndex(tx, msg.key())
+	if err != nil || !exists {
+		return exists, err
+	}
+	if err := tx.Delete(outboxIDsBucket, msg.ID); err != nil {
+		return true, err
+	}
+	return true, tx.Delete(outboxBucket, msg.key())
+}
+
+// dropDueIndex 删除数据库中已保存的消息在重发时间索引中的条目，返回消息是否存在
+// 无法解析的记录没有可用的重发时间，索引中残留的条目由 ListDueOutbox 清理
+func dropDueIndex(tx *store.Tx, key string) (bool, error) {
+	var old OutboxMessage
+	exists, err := tx.Get(outboxBucket, key, &old)
+	if !exists || err != nil {
+		return exists, nil
+	}
+	if old.Status == DeliverySent {
+		return true, tx.Delete(outboxDueBucket, old.dueKey())
+	}
+	return true, nil
+}
+
+// brokenOutboxIndex 找出标识索引和重发时间索引中指向 broken 中的消息键的条目，调用方需持有 OutboxMutex
+// 无法解析的记录没有可用的消息标识和重发时间，只能按索引的值查找
+func brokenOutboxIndex(broken []string) ([]string, []string, error) {
+	if len(broken) == 0 {
+		return nil, nil, nil
+	}
+	keys := make(map[string]bool, len(broken))
+	for _, key := range broken {
+		keys[key] = true
+	}
+	collect := func(bucket string, entries *[]string) error {
+		return store.ForEach(bucket, func(key string, data []byte) error {
+			var msgKey string
+			if err := json.Unmarshal(data, &msgKey); err == nil && keys[msgKey] {
+				*entries = append(*entries, key)
+			}
+			return nil
+		})
+	}
+
+	var ids, due []string
+	if err := collect(outboxIDsBucket, &ids); err != nil {
+		return nil, nil, err
+	}
+	if err := collect(outboxDueBucket, &due); err != nil {
+		return nil, nil, err
+	}
+	return ids, due, nil
+}
+
+// removeBrokenOutbox 在事务中删除无法解析的消息，以及 brokenOutboxIndex 找出的指向它们的索引条目
+func removeBrokenOutbox(tx *store.Tx, broken, ids, due []string) error {
+	for _, key := range broken {
+		if err := tx.Delete(outboxBucket, key); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		if err := tx.Delete(outboxIDsBucket, id); err != nil {
+			return err
+		}
+	}
+	for _, key := range due {
+		if err := tx.Delete(outboxDueBucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decOutboxCount 节点的待投递消息数减一，调用方需持有 OutboxMutex
+func decOutboxCount(uid string) {
+	if outboxCounts[uid] <= 1 {
+		delete(outboxCounts, uid)
+	} else {
+		outboxCounts[uid]--
+	}
+}
+
+// SaveOutboxMessage 写入新消息并分配序号，调用方需持有 OutboxMutex
+// 节点尚未结束投递的消息数超过 limit（大于 0 时）时，丢弃优先级最低、最早写入的消息并标记为失败，返回被丢弃的消息
+func SaveOutboxMessage(msg *OutboxMessage, limit int) ([]*OutboxMessage, error) {
+	msg.Seq = nextOutboxSeq()
+	if err := store.Update(func(tx *store.Tx) error { return putOutbox(tx, msg) }); err != nil {
+		return nil, err
+	}
+	outboxCounts[msg.UID]++
+	if limit <= 0 || outboxCounts[msg.UID] <= limit {
+		return nil, nil
+	}
+
+	// 超过上限时才读取节点的全部消息，选出需要丢弃的消息
+	pending, err := ListOutbox(msg.UID)
+	if err != nil {
+		return nil, err
+	}
+	var dropped []*OutboxMessage
+	for len(pending) > limit {
+		// 按投递顺序排列，优先级最低的消息在末尾，丢弃其中最早写入的一条
+		i := len(pending) - 1
+		for i > 0 && pending[i-1].Priority == pending[i].Priority {
+			i--
+		}
+		if err := FinishOutboxMessage(pending[i], DeliveryFailed, "待投递消息过多，已丢弃"); err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, pending[i])
+		pending = append(pending[:i], pending[i+1:]...)
+	}
+	return dropped, nil
+}
+
+// ListOutbox 按投递顺序列出节点尚未结束投递的消息：优先级高的在前，同优先级按写入顺序
+func ListOutbox(uid string) ([]*OutboxMessage, error) {
+	messages := []*OutboxMessage{}
+	err := store.ForEachPrefix(outboxBucket, uid+"/", func(key string, data []byte) error {
+		var msg OutboxMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			fmt.Printf("解析待投递消息 %s 失败: %v\n", key, err)
+			return nil
+		}
+		// 节点标识本身可能包含 /，前缀相同的其他节点的消息需要排除
+		if msg.UID == uid {
+			messages = append(messages, &msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Priority > messages[j].Priority
+	})
+	return messages, nil
+}
+
+// CountOutbox 统计各节点的待投递消息数
+func CountOutbox() (map[string]int, error) {
+	OutboxMutex.Lock()
+	defer OutboxMutex.Unlock()
+
+	counts := make(map[string]int, len(outboxCounts))
+	for uid, n := range outboxCounts {
+		counts[uid] = n
+	}
+	return counts, nil
+}
+
+// UpdateOutboxMessage 保存尚未结束投递的消息的状态，调用方需持有 OutboxMutex
+func UpdateOutboxMessage(msg *OutboxMessage) error {
+	return store.Update(func(tx *store.Tx) error { return putOutbox(tx, msg) })
+}
+
+// RemoveOutboxMessage 从待投递队列中删除消息，调用方需持有 OutboxMutex
+func RemoveOutboxMessage(msg *OutboxMessage) error {
+	var removed bool
+	err := store.Update(func(tx *store.Tx) error {
+		var err error
+		removed, err = removeOutbox(tx, msg)
+		return err
+	})
+	if err == nil && removed {
+		decOutboxCount(msg.UID)
+	}
+	return err
+}
+
+// FinishOutboxMessage 结束消息的投递：从待投递队列中移出，记录最终状态供发送方查询，调用方需持有 OutboxMutex
+func FinishOutboxMessage(msg *OutboxMessage, status DeliveryStatus, reason string) error {
+	msg.Status = status
+	msg.Error = reason
+	msg.DoneAt = time.Now()
+
+	var removed bool
+	err := store.Update(func(tx *store.Tx) error {
+		if err := tx.Put(deliveriesBucket, msg.ID, msg); err != nil {
+			return err
+		}
+		if err := tx.Put(deliveriesDoneBucket, msg.doneKey(), msg.ID); err != nil {
+			return err
+		}
+		var err error
+		removed, err = removeOutbox(tx, msg)
+		return err
+	})
+	if err == nil && removed {
+		decOutboxCount(msg.UID)
+	}
+	return err
+}
+
+// FindOutboxMessage 按消息标识查找消息，包括尚未结束投递和已结束投递的消息
+func FindOutboxMessage(id string) (*OutboxMessage, bool, error) {
+	var msg OutboxMessage
+	exists, err := store.Get(deliveriesBucket, id, &msg)
+	if err != nil || exists {
+		return &msg, exists, err
+	}
+	return getPendingOutboxMessage(id)
+}
+
+// getPendingOutboxMessage 按标识索引获取尚未结束投递的消息
+func getPendingOutboxMessage(id string) (*OutboxMessage, bool, error) {
+	var key string
+	exists, err := store.Get(outboxIDsBucket, id, &key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	var msg OutboxMessage
+	exists, err = store.Get(outboxBucket, key, &msg)
+	if err != nil || !exists || msg.ID != id {
+		return nil, false, err
+	}
+	return &msg, true, nil
+}
+
+// ListDueOutbox 列出已发送、未确认且到了重发时间的消息，调用方需持有 OutboxMutex
+// 只遍历重发时间索引中不晚于 now 的条目，并顺带删除与消息当前状态不一致的残留条目
+func ListDueOutbox(now time.Time) ([]*OutboxMessage, error) {
+	entries := make(map[string]string)
+	end := fmt.Sprintf("%020d", now.UnixNano()+1)
+	err := store.ForEachRange(outboxDueBucket, "", end, func(key string, data []byte) error {
+		var msgKey string
+		if err := json.Unmarshal(data, &msgKey); err == nil {
+			entries[key] = msgKey
+		} else {
+			entries[key] = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	due := []*OutboxMessage{}
+	var stale []string
+	for key, msgKey := range entries {
+		var msg OutboxMessage
+		exists, err := store.Get(outboxBucket, msgKey, &msg)
+		if msgKey == "" || !exists || err != nil || msg.Status != DeliverySent || msg.dueKey() != key {
+			stale = append(stale, key)
+			continue
+		}
+		due = append(due, &msg)
+	}
+	for _, key := range stale {
+		if err := store.Delete(outboxDueBucket, key); err != nil {
+			return due, err
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextRetryAt.Before(due[j].NextRetryAt)
+	})
+	return due, nil
+}
+
+// GetNodeOutboxMessage 获取节点尚未结束投递的消息，调用方需持有 OutboxMutex
+func GetNodeOutboxMessage(uid, id string) (*OutboxMessage, bool, error) {
+	msg, exists, err := getPendingOutboxMessage(id)
+	if err != nil || !exists || msg.UID != uid {
+		return nil, false, err
+	}
+	return msg, true, nil
+}
+
+// CleanupDeliveries 删除在 before 之前结束投递的记录，只遍历结束时间索引中早于 before 的条目
+func CleanupDeliveries(before time.Time) error {
+	var stale []string
+	err := store.ForEachRange(deliveriesDoneBucket, "", indexTime(before), func(key string, data []byte) error {
+		stale = append(stale, key)
+		return nil
+	})
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+
+	return store.Update(func(tx *store.Tx) error {
+		for _, key := range stale {
+			id := key[strings.Index(key, "/")+1:]
+			// 同一消息再次结束投递时索引中会有新的条目，只有与记录一致的条目才删除记录
+			var msg OutboxMessage
+			exists, err := tx.Get(deliveriesBucket, id, &msg)
+			if !exists || err != nil || msg.doneKey() == key {
+				if err := tx.Delete(deliveriesBucket, id); err != nil {
+					return err
+				}
+			}
+			if err := tx.Delete(deliveriesDoneBucket, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CleanupExpiredOutbox 将已过期的待投递消息标记为过期，并删除结束投递超过 retention 的记录，返回过期的消息数
+func CleanupExpiredOutbox(retention time.Duration) (int, error) {
+	OutboxMutex.Lock()
+	defer OutboxMutex.Unlock()
+
+	now := time.Now()
+	var expired []*OutboxMessage
+	var broken []string
+	err := store.ForEach(outboxBucket, func(key string, data []byte) error {
+		var msg OutboxMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			broken = append(broken, key)
+		} else if msg.Expired(now) {
+			expired = append(expired, &msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// 启动时已删除无法解析的记录，运行中损坏的记录写入时已计入节点的待投递消息数，消息键为 <uid>/<序号>
+	if len(broken) > 0 {
+		ids, due, err := brokenOutboxIndex(broken)
+		if err != nil {
+			return 0, err
+		}
+		if err := store.Update(func(tx *store.Tx) error { return removeBrokenOutbox(tx, broken, ids, due) }); err != nil {
+			return 0, err
+		}
+		for _, key := range broken {
+			if i := strings.LastIndex(key, "/"); i >= 0 {
+				decOutboxCount(key[:i])
+			}
+		}
+	}
+	for _, msg := range expired {
+		if err := FinishOutboxMessage(msg, DeliveryExpired, "消息已过期"); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(expired), CleanupDeliveries(now.Add(-retention))
+}
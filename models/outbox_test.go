@@ -0,0 +1,194 @@
+package models
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"com.example/relay/store"
+)
+
+func TestOutboxDelivery(t *testing.T) {
+	initTestStore(t)
+	if err := LoadOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	messages := make(map[string]*OutboxMessage)
+	newMessage := func(id, uid string, priority int) *OutboxMessage {
+		msg := &OutboxMessage{ID: id, UID: uid, Priority: priority, Data: json.RawMessage("{}"), Status: DeliveryPending, CreatedAt: now}
+		messages[id] = msg
+		return msg
+	}
+
+	// 除 CleanupExpiredOutbox 外，调用方需持有 OutboxMutex
+	save := func(msg *OutboxMessage, limit int) func() error {
+		return func() error {
+			OutboxMutex.Lock()
+			defer OutboxMutex.Unlock()
+			_, err := SaveOutboxMessage(msg, limit)
+			return err
+		}
+	}
+	send := func(id string, retryAfter time.Duration) func() error {
+		return func() error {
+			OutboxMutex.Lock()
+			defer OutboxMutex.Unlock()
+			msg := messages[id]
+			msg.Status = DeliverySent
+			msg.Attempts++
+			msg.NextRetryAt = now.Add(retryAfter)
+			return UpdateOutboxMessage(msg)
+		}
+	}
+	finish := func(id string, status DeliveryStatus) func() error {
+		return func() error {
+			OutboxMutex.Lock()
+			defer OutboxMutex.Unlock()
+			return FinishOutboxMessage(messages[id], status, "")
+		}
+	}
+	corrupt := func(id string) func() error {
+		return func() error { return store.Put(outboxBucket, messages[id].key(), "损坏的记录") }
+	}
+	cleanup := func(retention time.Duration) func() error {
+		return func() error {
+			_, err := CleanupExpiredOutbox(retention)
+			return err
+		}
+	}
+
+	// 按顺序执行，每一步之后检查各节点的待投递消息数、n1 的投递顺序、到期的重发消息、索引条目数和各消息的状态
+	steps := []struct {
+		name     string
+		op       func() error
+		counts   map[string]int
+		order    []string // n1 的投递顺序
+		due      []string // 一小时内需要重发的消息
+		indexed  int      // 标识索引和重发时间索引的条目总数
+		statuses map[string]DeliveryStatus
+	}{
+		{"写入消息", save(newMessage("m1", "n1", 0), 0), map[string]int{"n1": 1}, []string{"m1"}, nil, 1,
+			map[string]DeliveryStatus{"m1": DeliveryPending}},
+		{"高优先级的消息先投递", save(newMessage("m2", "n1", 5), 0), map[string]int{"n1": 2}, []string{"m2", "m1"}, nil, 2,
+			map[string]DeliveryStatus{"m2": DeliveryPending}},
+		{"其他节点的消息", save(newMessage("m3", "n2", 0), 0), map[string]int{"n1": 2, "n2": 1}, []string{"m2", "m1"}, nil, 3,
+			map[string]DeliveryStatus{"m3": DeliveryPending}},
+		{"发送后等待重发", send("m1", time.Minute), map[string]int{"n1": 2, "n2": 1}, []string{"m2", "m1"}, []string{"m1"}, 4,
+			map[string]DeliveryStatus{"m1": DeliverySent}},
+		{"重发时替换重发时间索引", send("m1", 2*time.Minute), map[string]int{"n1": 2, "n2": 1}, []string{"m2", "m1"}, []string{"m1"}, 4,
+			map[string]DeliveryStatus{"m1": DeliverySent}},
+		{"重发时间未到", send("m2", 2*time.Hour), map[string]int{"n1": 2, "n2": 1}, []string{"m2", "m1"}, []string{"m1"}, 5,
+			map[string]DeliveryStatus{"m2": DeliverySent}},
+		{"确认", finish("m1", DeliveryAcked), map[string]int{"n1": 1, "n2": 1}, []string{"m2"}, nil, 3,
+			map[string]DeliveryStatus{"m1": DeliveryAcked}},
+		{"拒绝", finish("m2", DeliveryNacked), map[string]int{"n2": 1}, []string{}, nil, 1,
+			map[string]DeliveryStatus{"m2": DeliveryNacked}},
+		{"超过上限时丢弃最早的消息", func() error {
+			if err := save(newMessage("m4", "n1", 0), 1)(); err != nil {
+				return err
+			}
+			return save(newMessage("m5", "n1", 0), 1)()
+		}, map[string]int{"n1": 1, "n2": 1}, []string{"m5"}, nil, 2,
+			map[string]DeliveryStatus{"m4": DeliveryFailed, "m5": DeliveryPending}},
+		{"重发次数用尽", func() error {
+			if err := send("m5", time.Minute)(); err != nil {
+				return err
+			}
+			return finish("m5", DeliveryFailed)()
+		}, map[string]int{"n2": 1}, []string{}, nil, 1,
+			map[string]DeliveryStatus{"m5": DeliveryFailed}},
+		{"过期的消息", func() error {
+			msg := newMessage("m6", "n1", 0)
+			msg.ExpiresAt = now.Add(-time.Second)
+			if err := save(msg, 0)(); err != nil {
+				return err
+			}
+			return cleanup(time.Hour)()
+		}, map[string]int{"n2": 1}, []string{}, nil, 1,
+			map[string]DeliveryStatus{"m6": DeliveryExpired, "m1": DeliveryAcked}},
+		{"删除无法解析的消息及其索引", func() error {
+			if err := send("m3", time.Minute)(); err != nil {
+				return err
+			}
+			if err := corrupt("m3")(); err != nil {
+				return err
+			}
+			return cleanup(time.Hour)()
+		}, map[string]int{}, []string{}, nil, 0,
+			map[string]DeliveryStatus{"m3": ""}},
+		{"删除超过保留时间的投递记录", cleanup(0), map[string]int{}, []string{}, nil, 0,
+			map[string]DeliveryStatus{"m1": "", "m2": "", "m4": "", "m5": "", "m6": ""}},
+	}
+
+	for _, step := range steps {
+		if err := step.op(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		counts, err := CountOutbox()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(counts, step.counts) {
+			t.Fatalf("%s: 待投递消息数 = %v，期望 %v", step.name, counts, step.counts)
+		}
+
+		pending, err := ListOutbox("n1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		order := []string{}
+		for _, msg := range pending {
+			order = append(order, msg.ID)
+		}
+		if !slices.Equal(order, step.order) {
+			t.Fatalf("%s: 投递顺序 = %v，期望 %v", step.name, order, step.order)
+		}
+
+		OutboxMutex.Lock()
+		due, err := ListDueOutbox(now.Add(time.Hour))
+		OutboxMutex.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dueIDs []string
+		for _, msg := range due {
+			dueIDs = append(dueIDs, msg.ID)
+		}
+		if !slices.Equal(dueIDs, step.due) {
+			t.Fatalf("%s: 需要重发的消息 = %v，期望 %v", step.name, dueIDs, step.due)
+		}
+
+		indexed := 0
+		for _, bucket := range []string{outboxIDsBucket, outboxDueBucket} {
+			if err := store.ForEach(bucket, func(key string, data []byte) error {
+				indexed++
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if indexed != step.indexed {
+			t.Fatalf("%s: 索引条目数 = %d，期望 %d", step.name, indexed, step.indexed)
+		}
+
+		for id, status := range step.statuses {
+			msg, exists, err := FindOutboxMessage(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := DeliveryStatus(""); exists {
+				got = msg.Status
+				if got != status {
+					t.Fatalf("%s: 消息 %s 的状态 = %q，期望 %q", step.name, id, got, status)
+				}
+			} else if status != "" {
+				t.Fatalf("%s: 找不到消息 %s", step.name, id)
+			}
+		}
+	}
+}
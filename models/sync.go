@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"com.example/relay/store"
+)
+
+// syncJobsBucket 同步任务在数据库中的桶名
+const syncJobsBucket = "sync_jobs"
+
+// SyncJob 同步任务，从节点上传同步文件开始，到节点确认同步完成为止
+type SyncJob struct {
+	Name      string    `json:"name"`       // 同步文件在存储中的文件名
+	UID       string    `json:"uid"`        // 同步目标节点
+	FileName  string    `json:"filename"`   // 节点侧的资源名称
+	CreatedAt time.Time `json:"created_at"` // 创建时间
+}
+
+// SaveSyncJob 记录同步任务，同一文件的旧任务会被覆盖
+func SaveSyncJob(job *SyncJob) error {
+	return store.Put(syncJobsBucket, job.Name, job)
+}
+
+// GetSyncJob 获取引用该文件的同步任务
+func GetSyncJob(name string) (*SyncJob, bool, error) {
+	var job SyncJob
+	exists, err := store.Get(syncJobsBucket, name, &job)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	return &job, true, nil
+}
+
+// RemoveSyncJob 删除同步任务
+func RemoveSyncJob(name string) error {
+	return store.Delete(syncJobsBucket, name)
+}
@@ -1,20 +1,56 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
+	"time"
+
+	"com.example/relay/store"
+	"com.example/relay/utils"
+)
+
+// uploadsBucket 上传会话在数据库中的桶名
+const uploadsBucket = "uploads"
+
+// UploadProtocol 上传会话使用的协议
+type UploadProtocol string
+
+const (
+	ProtocolChunk UploadProtocol = ""    // 自有的 init/chunk/complete 分块协议
+	ProtocolTus   UploadProtocol = "tus" // tus 1.0 可续传协议
 )
 
 // UploadInfo 上传信息结构体
 type UploadInfo struct {
-	FileID      string         // 文件唯一标识
-	FileName    string         // 文件名
-	TotalChunks int            // 总块数
-	TotalSize   int64          // 文件总大小
-	ChunkSize   int64          // 每个块的大小
-	Completed   []bool         // 已完成的块
-	FileHash    string         // 整个文件的哈希值（由客户端提供）
-	ChunkHashes map[int]string // 分块哈希值映射
-	Mu          sync.Mutex
+	FileID      string         `json:"file_id"`      // 文件唯一标识
+	FileName    string         `json:"file_name"`    // 文件名
+	TotalChunks int            `json:"total_chunks"` // 总块数
+	TotalSize   int64          `json:"total_size"`   // 文件总大小
+	ChunkSize   int64          `json:"chunk_size"`   // 每个块的大小
+	Completed   []bool         `json:"completed"`    // 已完成的块
+	FileHash    string         `json:"file_hash"`    // 整个文件的哈希值（由客户端提供）
+	HashAlgo    string         `json:"hash_algo"`    // FileHash 和分块哈希值使用的哈希算法
+	MerkleRoot  string         `json:"merkle_root"`  // 分块哈希值构成的 Merkle 树的根哈希（由客户端提供）
+	Owner       string         `json:"owner"`        // 上传者（节点ID或客户端标识）
+	Overwrite   bool           `json:"overwrite"`    // 是否允许覆盖内容不同的同名文件
+	ChunkHashes map[int]string `json:"chunk_hashes"` // 分块哈希值映射
+	CreatedAt   time.Time      `json:"created_at"`   // 创建时间
+	UpdatedAt   time.Time      `json:"updated_at"`   // 最近一次更新时间
+
+	Protocol  UploadProtocol    `json:"protocol,omitempty"`   // 上传协议
+	Metadata  map[string]string `json:"metadata,omitempty"`   // 客户端提供的元数据（tus Upload-Metadata）
+	ExpiresAt time.Time         `json:"expires_at,omitempty"` // 会话过期时间，零值表示不过期
+
+	Positional bool `json:"positional,omitempty"` // 分块按偏移量直接写入预分配的文件，而不是单独的分块文件
+	Finished   bool `json:"finished,omitempty"`   // 数据已全部接收并纳入存储，tus 会话保留到过期供客户端查询偏移量，受 Mu 保护
+
+	Mu         sync.Mutex          `json:"-"`
+	WriteMu    sync.Mutex          `json:"-"` // 串行化对同一会话分块文件的写入
+	Completing bool                `json:"-"` // 正在合并分块，同一会话同时只允许一个完成请求，受 Mu 保护
+	chunkLocks map[int]*sync.Mutex // 各分块的写入锁，受 Mu 保护
 }
 
 // Uploads 全局上传信息记录
@@ -31,3 +67,146 @@ func CountCompletedChunks(completed []bool) int {
 	}
 	return count
 }
+
+// MissingChunks 返回尚未上传的分块索引，调用方需持有 info.Mu
+func (info *UploadInfo) MissingChunks() []int {
+	missing := []int{}
+	for i, done := range info.Completed {
+		if !done {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// LockChunk 锁定指定分块，串行化对同一分块的并发写入，返回解锁函数；调用方不能持有 info.Mu
+func (info *UploadInfo) LockChunk(chunkIndex int) func() {
+	info.Mu.Lock()
+	if info.chunkLocks == nil {
+		info.chunkLocks = make(map[int]*sync.Mutex)
+	}
+	lock, ok := info.chunkLocks[chunkIndex]
+	if !ok {
+		lock = &sync.Mutex{}
+		info.chunkLocks[chunkIndex] = lock
+	}
+	info.Mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// ChunkFileName 分块临时文件名
+func ChunkFileName(fileID string, chunkIndex int) string {
+	return fmt.Sprintf("%s-%d", fileID, chunkIndex)
+}
+
+// PartFileName 按偏移量写入的上传会话使用的预分配文件名
+func PartFileName(fileID string) string {
+	return fileID + ".part"
+}
+
+// ChunkLength 计算指定分块的实际大小（最后一块可能小于分块大小）
+func (info *UploadInfo) ChunkLength(chunkIndex int) int64 {
+	start := int64(chunkIndex) * info.ChunkSize
+	if end := start + info.ChunkSize; end < info.TotalSize {
+		return info.ChunkSize
+	}
+	return info.TotalSize - start
+}
+
+// HashAlgorithm 会话使用的哈希算法，早期版本创建的会话没有记录算法，使用的是MD5
+func (info *UploadInfo) HashAlgorithm() string {
+	if info.HashAlgo == "" {
+		return utils.DefaultHashAlgo
+	}
+	return info.HashAlgo
+}
+
+// SaveUploadInfo 将上传会话写入磁盘日志，调用方不能持有 info.Mu
+func SaveUploadInfo(info *UploadInfo) error {
+	info.Mu.Lock()
+	defer info.Mu.Unlock()
+
+	info.UpdatedAt = time.Now()
+	return store.Put(uploadsBucket, info.FileID, info)
+}
+
+// RemoveUploadInfo 删除上传会话（内存与磁盘）
+func RemoveUploadInfo(fileID string) error {
+	UploadsMutex.Lock()
+	delete(Uploads, fileID)
+	UploadsMutex.Unlock()
+
+	return store.Delete(uploadsBucket, fileID)
+}
+
+// LoadUploads 启动时从磁盘恢复上传会话
+// 以临时目录中实际存在的分块文件为准重新计算已完成的分块，
+// 大小不符的分块视为写入中断，删除后由客户端重新上传；
+// tus 会话按偏移量续传，保留未写满的分块；
+// 按偏移量写入的会话无法从文件判断哪些分块已写入，以记录为准，预分配文件丢失时重新上传全部分块
+func LoadUploads(tempDir string) error {
+	var infos []*UploadInfo
+	err := store.ForEach(uploadsBucket, func(key string, data []byte) error {
+		info := &UploadInfo{}
+		if err := json.Unmarshal(data, info); err != nil {
+			fmt.Printf("解析上传会话 %s 失败，已忽略: %v\n", key, err)
+			return nil
+		}
+		infos = append(infos, info)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	UploadsMutex.Lock()
+	defer UploadsMutex.Unlock()
+
+	for _, info := range infos {
+		// 已完成的 tus 会话只保留记录，分块已删除
+		if info.Finished {
+			Uploads[info.FileID] = info
+			continue
+		}
+		if info.ChunkHashes == nil {
+			info.ChunkHashes = make(map[int]string)
+		}
+		if len(info.Completed) != info.TotalChunks {
+			info.Completed = make([]bool, info.TotalChunks)
+		}
+
+		if info.Positional {
+			stat, err := os.Stat(filepath.Join(tempDir, PartFileName(info.FileID)))
+			if err != nil || stat.Size() != info.TotalSize {
+				info.Completed = make([]bool, info.TotalChunks)
+				info.ChunkHashes = make(map[int]string)
+			}
+		}
+
+		for i := 0; i < info.TotalChunks && !info.Positional; i++ {
+			chunkPath := filepath.Join(tempDir, ChunkFileName(info.FileID, i))
+			stat, err := os.Stat(chunkPath)
+			switch {
+			case err == nil && stat.Size() == info.ChunkLength(i):
+				info.Completed[i] = true
+			case err == nil && info.Protocol != ProtocolTus:
+				os.Remove(chunkPath)
+				fallthrough
+			default:
+				info.Completed[i] = false
+				delete(info.ChunkHashes, i)
+			}
+		}
+
+		Uploads[info.FileID] = info
+		if err := store.Put(uploadsBucket, info.FileID, info); err != nil {
+			return err
+		}
+		fmt.Printf("已恢复上传会话 %s（%s），已完成 %d/%d 块\n",
+			info.FileID, info.FileName, CountCompletedChunks(info.Completed), info.TotalChunks)
+	}
+
+	return nil
+}
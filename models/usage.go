@@ -0,0 +1,103 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"com.example/relay/store"
+)
+
+// Usage 上传者已占用的存储空间，按文件索引统计，相同内容被多个文件引用时分别计算
+type Usage struct {
+	Files int   `json:"files"` // 文件数
+	Bytes int64 `json:"bytes"` // 文件大小合计
+}
+
+var (
+	// usages 各上传者的存储用量，键为文件索引中的 Owner，未提供上传者的文件记在空字符串下
+	usages = make(map[string]*Usage)
+	// usagesMutex 保护 usages
+	usagesMutex sync.Mutex
+)
+
+// LoadUsage 扫描文件索引，统计各上传者的存储用量
+func LoadUsage() error {
+	loaded := make(map[string]*Usage)
+	err := store.ForEach(filesBucket, func(key string, data []byte) error {
+		var meta FileMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			fmt.Printf("解析文件索引 %s 失败: %v\n", key, err)
+			return nil
+		}
+		usage, ok := loaded[meta.Owner]
+		if !ok {
+			usage = &Usage{}
+			loaded[meta.Owner] = usage
+		}
+		usage.Files++
+		usage.Bytes += meta.Size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	usagesMutex.Lock()
+	usages = loaded
+	usagesMutex.Unlock()
+	return nil
+}
+
+// OwnerUsage 获取上传者的存储用量
+func OwnerUsage(owner string) Usage {
+	usagesMutex.Lock()
+	defer usagesMutex.Unlock()
+
+	if usage, ok := usages[owner]; ok {
+		return *usage
+	}
+	return Usage{}
+}
+
+// AllUsage 获取所有上传者的存储用量
+func AllUsage() map[string]Usage {
+	usagesMutex.Lock()
+	defer usagesMutex.Unlock()
+
+	all := make(map[string]Usage, len(usages))
+	for owner, usage := range usages {
+		all[owner] = *usage
+	}
+	return all
+}
+
+// TotalUsage 所有文件的存储用量合计
+func TotalUsage() Usage {
+	usagesMutex.Lock()
+	defer usagesMutex.Unlock()
+
+	var total Usage
+	for _, usage := range usages {
+		total.Files += usage.Files
+		total.Bytes += usage.Bytes
+	}
+	return total
+}
+
+// addUsage 调整上传者的存储用量，文件数归零时删除记录
+func addUsage(owner string, files int, bytes int64) {
+	usagesMutex.Lock()
+	defer usagesMutex.Unlock()
+
+	usage, ok := usages[owner]
+	if !ok {
+		usage = &Usage{}
+		usages[owner] = usage
+	}
+	usage.Files += files
+	usage.Bytes += bytes
+	if usage.Files <= 0 {
+		delete(usages, owner)
+	}
+}
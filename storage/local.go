@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local 本地文件系统存储，对象键映射为根目录下的相对路径
+type Local struct {
+	root     string
+	realRoot string // 解析符号链接后的根目录，用于检查路径是否逃逸
+}
+
+// NewLocal 创建以 root 为根目录的本地存储
+func NewLocal(root string) (*Local, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	realRoot, err = filepath.Abs(realRoot)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{root: root, realRoot: realRoot}, nil
+}
+
+// path 对象键对应的本地路径
+// 拒绝包含 .. 等非法路径段的键，以及经由符号链接指向根目录之外的路径
+func (l *Local) path(key string) (string, error) {
+	if key == "." || !fs.ValidPath(key) {
+		return "", ErrInvalidKey
+	}
+	p := filepath.Join(l.root, filepath.FromSlash(key))
+
+	// 目标可能尚未创建，从目标向上找到第一个已存在的路径，解析其中的符号链接
+	existing := p
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if os.IsNotExist(err) && existing != l.root {
+			existing = filepath.Dir(existing)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if resolved, err = filepath.Abs(resolved); err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(l.realRoot, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", ErrInvalidKey
+		}
+		return p, nil
+	}
+}
+
+// Put 先写入同目录下的临时文件并落盘，再重命名为目标文件，读取方不会看到写了一半的文件
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	dst, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dst))
+}
+
+// Import 以硬链接的方式将本地文件导入为对象，不在同一文件系统时退化为复制
+func (l *Local) Import(ctx context.Context, key, src string) error {
+	dst, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return syncDir(filepath.Dir(dst))
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return l.Put(ctx, key, file, -1)
+}
+
+// syncDir 将目录项的变更落盘，保证重命名在断电后依然有效
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Get 读取对象的指定区间
+func (l *Local) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return file, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+// Stat 获取对象元信息
+func (l *Local) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, ErrNotExist
+	}
+
+	return &ObjectInfo{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+// Delete 删除对象
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List 列出键以 prefix 开头的所有对象，跳过写入中的临时文件和符号链接
+func (l *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// 从前缀中最深的目录开始遍历，避免扫描整个根目录
+	start := l.root
+	if dir := path.Dir(prefix); strings.Contains(prefix, "/") && dir != "." {
+		var err error
+		if start, err = l.path(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	objects := []ObjectInfo{}
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || d.Type()&fs.ModeSymlink != 0 || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+
+	return objects, err
+}
+
+// SweepTemp 删除 prefix 目录下修改时间早于 before 的 .tmp- 临时文件
+// Put 和 Compose 在目标目录中写入临时文件，进程在重命名之前中断时会残留
+func (l *Local) SweepTemp(ctx context.Context, prefix string, before time.Time) ([]ObjectInfo, error) {
+	start, err := l.path(strings.TrimSuffix(prefix, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []ObjectInfo{}
+	err = filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || d.Type()&fs.ModeSymlink != 0 || !strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed = append(removed, ObjectInfo{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return removed, err
+}
+
+// Compose 拼接多个对象；只有一个源对象时优先使用硬链接，避免复制数据
+func (l *Local) Compose(ctx context.Context, dst string, srcs []string) error {
+	if len(srcs) == 1 {
+		dstPath, err := l.path(dst)
+		if err != nil {
+			return err
+		}
+		srcPath, err := l.path(srcs[0])
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return err
+		}
+		// 先链接到临时名称再重命名，替换已有对象时不会出现目标缺失的窗口
+		tmpPath := filepath.Join(filepath.Dir(dstPath), ".tmp-link-"+filepath.Base(dstPath))
+		os.Remove(tmpPath)
+		if err := os.Link(srcPath, tmpPath); err == nil {
+			// 源和目标已是同一文件的硬链接时重命名不做任何事，临时名称需要单独删除
+			err := os.Rename(tmpPath, dstPath)
+			os.Remove(tmpPath)
+			if err != nil {
+				return err
+			}
+			return syncDir(filepath.Dir(dstPath))
+		}
+	}
+
+	readers := make([]io.Reader, 0, len(srcs))
+	for _, src := range srcs {
+		r, err := l.Get(ctx, src, 0, -1)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+
+	return l.Put(ctx, dst, io.MultiReader(readers...), -1)
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalPath(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "dir"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 指向根目录之外的目录和文件，以及指向根目录之内的目录
+	links := map[string]string{
+		filepath.Join(root, "escape"):       outside,
+		filepath.Join(root, "dir", "up"):    base,
+		filepath.Join(root, "secret"):       filepath.Join(outside, "secret.txt"),
+		filepath.Join(root, "inside"):       filepath.Join(root, "dir"),
+		filepath.Join(root, "dir", "loop"):  filepath.Join(root, "dir"),
+		filepath.Join(root, "dir", "chain"): filepath.Join(root, "escape"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("无法创建符号链接: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"普通文件", "a.txt", true},
+		{"尚未创建的多级目录", "new/sub/a.txt", true},
+		{"已存在的目录", "dir/a.txt", true},
+		{"指向根目录内的符号链接", "inside/a.txt", true},
+		{"根目录内的循环目录链接", "dir/loop/a.txt", true},
+
+		{"根目录", ".", false},
+		{"空键", "", false},
+		{"上级目录", "../outside/secret.txt", false},
+		{"中间的上级目录", "dir/../../outside", false},
+		{"绝对路径", "/etc/passwd", false},
+		{"结尾的斜杠", "dir/", false},
+		{"符号链接逃逸到根目录之外", "escape/secret.txt", false},
+		{"经由符号链接创建新文件", "escape/new/a.txt", false},
+		{"子目录中的符号链接逃逸", "dir/up/outside/secret.txt", false},
+		{"指向外部文件的符号链接", "secret", false},
+		{"链式符号链接逃逸", "dir/chain/secret.txt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := l.path(tt.key)
+			if !tt.ok {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Fatalf("path(%q) = %q, %v，期望 ErrInvalidKey", tt.key, p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("path(%q): %v", tt.key, err)
+			}
+			if !strings.HasPrefix(p, root+string(filepath.Separator)) {
+				t.Fatalf("path(%q) = %q，不在根目录 %q 之下", tt.key, p, root)
+			}
+		})
+	}
+}
+
+func TestLocalSymlinkEscape(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("无法创建符号链接: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := l.Get(ctx, "escape/secret.txt", 0, -1); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Get 错误 = %v，期望 ErrInvalidKey", err)
+	}
+	if _, err := l.Stat(ctx, "escape/secret.txt"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Stat 错误 = %v，期望 ErrInvalidKey", err)
+	}
+	if err := l.Put(ctx, "escape/new.txt", strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Put 错误 = %v，期望 ErrInvalidKey", err)
+	}
+	if err := l.Delete(ctx, "escape/secret.txt"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Delete 错误 = %v，期望 ErrInvalidKey", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !os.IsNotExist(err) {
+		t.Fatal("Put 经由符号链接在根目录之外创建了文件")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.txt")); err != nil {
+		t.Fatal("Delete 经由符号链接删除了根目录之外的文件")
+	}
+}
+
+func TestLocalSweepTemp(t *testing.T) {
+	root := t.TempDir()
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	files := []struct {
+		key   string
+		old   bool
+		swept bool
+	}{
+		{"blobs/ab/.tmp-123", true, true},
+		{"blobs/ab/.tmp-link-abcdef", true, true},
+		{"blobs/ab/.tmp-456", false, false},
+		{"blobs/ab/abcdef", true, false},
+		{"staging/.tmp-789", true, true},
+		{"dir/.tmp-000", true, false},
+	}
+	for _, f := range files {
+		p := filepath.Join(root, filepath.FromSlash(f.key))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if f.old {
+			if err := os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	before := time.Now().Add(-time.Hour)
+	removed := 0
+	for _, prefix := range []string{"blobs/", "staging/", "missing/"} {
+		objects, err := l.SweepTemp(ctx, prefix, before)
+		if err != nil {
+			t.Fatalf("SweepTemp(%q): %v", prefix, err)
+		}
+		removed += len(objects)
+	}
+
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(root, filepath.FromSlash(f.key)))
+		if f.swept != os.IsNotExist(err) {
+			t.Errorf("%s 是否被删除 = %v，期望 %v", f.key, os.IsNotExist(err), f.swept)
+		}
+	}
+	if removed != 3 {
+		t.Fatalf("删除 %d 个文件，期望 3 个", removed)
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"com.example/relay/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3MinPartSize S3 服务端拼接时除最后一部分外每部分的最小大小
+const s3MinPartSize = 5 << 20
+
+// S3 兼容 S3 协议的对象存储（AWS S3、MinIO 等）
+type S3 struct {
+	client *minio.Client
+	core   *minio.Core
+	bucket string
+	prefix string
+}
+
+// NewS3 创建 S3 存储，桶不存在时自动创建
+func NewS3(cfg *config.S3Config) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建 S3 客户端失败: %w", err)
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("检查 S3 桶失败: %w", err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("创建 S3 桶失败: %w", err)
+		}
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{client: client, core: &minio.Core{Client: client}, bucket: cfg.Bucket, prefix: prefix}, nil
+}
+
+// object 对象键对应的 S3 对象名
+func (s *S3) object(key string) string {
+	return s.prefix + key
+}
+
+// Put 写入对象，size 未知时由客户端自动使用分片上传
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.object(key), r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+// Get 使用 Range 请求读取对象的指定区间
+func (s *S3) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	switch {
+	case length > 0:
+		if err := opts.SetRange(offset, offset+length-1); err != nil {
+			return nil, err
+		}
+	case offset > 0:
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	// 使用 Core 接口立即发起请求，以便在返回前发现对象不存在等错误
+	body, _, _, err := s.core.GetObject(ctx, s.bucket, s.object(key), opts)
+	if err != nil {
+		return nil, s.convertError(err)
+	}
+	return body, nil
+}
+
+// Stat 获取对象元信息
+func (s *S3) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.object(key), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.convertError(err)
+	}
+	return &ObjectInfo{Key: key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+// Delete 删除对象
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.object(key), minio.RemoveObjectOptions{})
+}
+
+// List 列出键以 prefix 开头的所有对象
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.object(prefix),
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, s.convertError(info.Err)
+		}
+		objects = append(objects, ObjectInfo{
+			Key:     strings.TrimPrefix(info.Key, s.prefix),
+			Size:    info.Size,
+			ModTime: info.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+// Compose 拼接多个对象
+// 满足服务端拼接条件（除最后一个外每个源对象不小于 5 MiB）时在服务端完成，否则流式读取后重新写入
+// 单个源对象同样交给 ComposeObject：CopyObject 最多只能复制 5 GiB，更大的对象需要分片复制
+func (s *S3) Compose(ctx context.Context, dst string, srcs []string) error {
+	dstOpts := minio.CopyDestOptions{Bucket: s.bucket, Object: s.object(dst)}
+
+	var total int64
+	serverSide := true
+	srcOpts := make([]minio.CopySrcOptions, 0, len(srcs))
+	for i, src := range srcs {
+		info, err := s.Stat(ctx, src)
+		if err != nil {
+			return err
+		}
+		total += info.Size
+		if i < len(srcs)-1 && info.Size < s3MinPartSize {
+			serverSide = false
+		}
+		srcOpts = append(srcOpts, minio.CopySrcOptions{Bucket: s.bucket, Object: s.object(src)})
+	}
+
+	if serverSide {
+		_, err := s.client.ComposeObject(ctx, dstOpts, srcOpts...)
+		return s.convertError(err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, src := range srcs {
+			r, err := s.Get(ctx, src, 0, -1)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(pw, r)
+			r.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	err := s.Put(ctx, dst, pr, total)
+	pr.CloseWithError(err)
+	return err
+}
+
+// convertError 将对象不存在的错误转换为 ErrNotExist
+func (s *S3) convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrNotExist
+	}
+	return err
+}
@@ -0,0 +1,552 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"com.example/relay/config"
+)
+
+// s3CopyObjectLimit S3 单次 CopyObject 允许的最大源对象大小
+const s3CopyObjectLimit = 5 << 30
+
+// fakeObject 模拟存储中的对象，data 为 nil 时表示只有大小、内容按偏移量生成的大对象
+type fakeObject struct {
+	data    []byte
+	size    int64
+	modTime time.Time
+}
+
+// read 读取 [offset, offset+length) 区间的内容
+func (o *fakeObject) read(offset, length int64) []byte {
+	if o.data != nil {
+		return o.data[offset : offset+length]
+	}
+	buf := make([]byte, length)
+	for i := range buf {
+		buf[i] = byte((offset + int64(i)) % 251)
+	}
+	return buf
+}
+
+// slice 截取 [offset, offset+length) 区间作为新对象，大对象截取后仍只记录大小
+func (o *fakeObject) slice(offset, length int64) *fakeObject {
+	if o.data == nil {
+		return &fakeObject{size: length}
+	}
+	return &fakeObject{data: append([]byte(nil), o.read(offset, length)...), size: length}
+}
+
+// fakeS3 兼容 S3 协议的最小服务端，只实现存储驱动用到的接口
+// 与 S3 一致，CopyObject 拒绝超过 5 GiB 的源对象，更大的对象只能通过分片复制
+type fakeS3 struct {
+	mu        sync.Mutex
+	buckets   map[string]bool
+	objects   map[string]*fakeObject
+	uploads   map[string]map[int]*fakeObject
+	nextID    int
+	partCalls int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		buckets: map[string]bool{},
+		objects: map[string]*fakeObject{},
+		uploads: map[string]map[int]*fakeObject{},
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 先读完请求体再加锁：流式拼接时写入目标对象的同时还在读取源对象
+	var body []byte
+	if r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") == "" {
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		body = data
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !f.buckets[bucket] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.buckets[bucket] = true
+		case http.MethodGet:
+			f.listObjects(w, bucket, query.Get("prefix"))
+		default:
+			writeS3Error(w, http.StatusNotImplemented, "NotImplemented", r.Method)
+		}
+		return
+	}
+	name := bucket + "/" + key
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		uploadID := strconv.Itoa(f.nextID)
+		f.uploads[uploadID] = map[int]*fakeObject{}
+		writeS3XML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: uploadID})
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.completeUpload(w, r, name, query.Get("uploadId"))
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.uploadPart(w, r, query.Get("uploadId"), query.Get("partNumber"), body)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.copyObject(w, r, name)
+	case r.Method == http.MethodPut:
+		f.objects[name] = &fakeObject{data: body, size: int64(len(body)), modTime: time.Now()}
+		w.Header().Set("ETag", etagOf(body))
+	case r.Method == http.MethodHead:
+		obj, ok := f.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeObjectHeaders(w, obj)
+	case r.Method == http.MethodGet:
+		f.getObject(w, r, name)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", r.Method)
+	}
+}
+
+func (f *fakeS3) getObject(w http.ResponseWriter, r *http.Request, name string) {
+	obj, ok := f.objects[name]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	start, end := int64(0), obj.size-1
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		from, to, _ := strings.Cut(strings.TrimPrefix(rng, "bytes="), "-")
+		start, _ = strconv.ParseInt(from, 10, 64)
+		if to != "" {
+			end, _ = strconv.ParseInt(to, 10, 64)
+		}
+		if end >= obj.size {
+			end = obj.size - 1
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, obj.size))
+	}
+
+	writeObjectHeaders(w, obj)
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(status)
+	w.Write(obj.read(start, end-start+1))
+}
+
+func (f *fakeS3) copyObject(w http.ResponseWriter, r *http.Request, name string) {
+	src, ok := f.copySource(r)
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if src.size > s3CopyObjectLimit {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest",
+			"The specified copy source is larger than the maximum allowable size for a copy source: 5368709120")
+		return
+	}
+
+	obj := src.slice(0, src.size)
+	obj.modTime = time.Now()
+	f.objects[name] = obj
+	writeS3XML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: `"copy"`, LastModified: obj.modTime.UTC().Format(time.RFC3339)})
+}
+
+func (f *fakeS3) uploadPart(w http.ResponseWriter, r *http.Request, uploadID, partNumber string, body []byte) {
+	parts, ok := f.uploads[uploadID]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", uploadID)
+		return
+	}
+	number, _ := strconv.Atoi(partNumber)
+
+	// 普通分片上传
+	if r.Header.Get("X-Amz-Copy-Source") == "" {
+		parts[number] = &fakeObject{data: body, size: int64(len(body))}
+		w.Header().Set("ETag", etagOf(body))
+		return
+	}
+
+	// 分片复制，单个分片同样不能超过 5 GiB
+	f.partCalls++
+	src, ok := f.copySource(r)
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	start, end := int64(0), src.size-1
+	if rng := r.Header.Get("X-Amz-Copy-Source-Range"); rng != "" {
+		from, to, _ := strings.Cut(strings.TrimPrefix(rng, "bytes="), "-")
+		start, _ = strconv.ParseInt(from, 10, 64)
+		end, _ = strconv.ParseInt(to, 10, 64)
+	}
+	if end-start+1 > s3CopyObjectLimit {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "part too large")
+		return
+	}
+	parts[number] = src.slice(start, end-start+1)
+	writeS3XML(w, struct {
+		XMLName      xml.Name `xml:"CopyPartResult"`
+		ETag         string
+		LastModified string
+	}{ETag: fmt.Sprintf(`"part-%d"`, number), LastModified: time.Now().UTC().Format(time.RFC3339)})
+}
+
+func (f *fakeS3) completeUpload(w http.ResponseWriter, r *http.Request, name, uploadID string) {
+	parts, ok := f.uploads[uploadID]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", uploadID)
+		return
+	}
+	var body struct {
+		Parts []struct {
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	// 任何一个分片只记录大小时，拼接结果同样只记录大小
+	obj := &fakeObject{data: []byte{}, modTime: time.Now()}
+	for _, p := range body.Parts {
+		part, ok := parts[p.PartNumber]
+		if !ok {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPart", strconv.Itoa(p.PartNumber))
+			return
+		}
+		obj.size += part.size
+		if obj.data != nil && part.data != nil {
+			obj.data = append(obj.data, part.data...)
+		} else {
+			obj.data = nil
+		}
+	}
+	f.objects[name] = obj
+	delete(f.uploads, uploadID)
+
+	bucket, key, _ := strings.Cut(name, "/")
+	writeS3XML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: bucket, Key: key, ETag: `"multipart"`})
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, bucket, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []content
+	}{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+
+	names := make([]string, 0, len(f.objects))
+	for name := range f.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := strings.TrimPrefix(name, bucket+"/")
+		if key == name || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		obj := f.objects[name]
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: obj.modTime.UTC().Format(time.RFC3339),
+			ETag:         `"etag"`,
+			Size:         obj.size,
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	writeS3XML(w, result)
+}
+
+// copySource 解析 x-amz-copy-source 指向的源对象
+func (f *fakeS3) copySource(r *http.Request) (*fakeObject, bool) {
+	src, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return nil, false
+	}
+	src, _, _ = strings.Cut(strings.TrimPrefix(src, "/"), "?")
+	obj, ok := f.objects[src]
+	return obj, ok
+}
+
+// readS3Body 读取请求体，客户端在非 HTTPS 连接上使用 aws-chunked 分块签名编码
+func readS3Body(r *http.Request) ([]byte, error) {
+	if r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		return io.ReadAll(r.Body)
+	}
+
+	var data []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func writeObjectHeaders(w http.ResponseWriter, obj *fakeObject) {
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.size, 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"etag"`)
+	w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+}
+
+func writeS3XML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// newTestS3 启动模拟服务端并创建指向它的 S3 存储
+func newTestS3(t *testing.T) (*S3, *fakeS3) {
+	t.Helper()
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s, err := NewS3(&config.S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Bucket:    "relay",
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Prefix:    "/data/",
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	return s, fake
+}
+
+func TestS3PutGetStatListDelete(t *testing.T) {
+	s, fake := newTestS3(t)
+	ctx := context.Background()
+
+	if !fake.buckets["relay"] {
+		t.Fatal("NewS3 未创建桶")
+	}
+	if err := s.Put(ctx, "dir/a.txt", strings.NewReader("hello world"), 11); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := fake.objects["relay/data/dir/a.txt"]; !ok {
+		t.Fatal("对象名未加上前缀")
+	}
+
+	info, err := s.Stat(ctx, "dir/a.txt")
+	if err != nil || info.Size != 11 {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+
+	r, err := s.Get(ctx, "dir/a.txt", 6, 5)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "world" {
+		t.Fatalf("Get(6, 5) = %q", data)
+	}
+
+	objects, err := s.List(ctx, "dir/")
+	if err != nil || len(objects) != 1 || objects[0].Key != "dir/a.txt" {
+		t.Fatalf("List = %+v, %v", objects, err)
+	}
+
+	if err := s.Delete(ctx, "dir/a.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat(ctx, "dir/a.txt"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("删除后 Stat 错误 = %v，期望 ErrNotExist", err)
+	}
+	if _, err := s.Get(ctx, "dir/a.txt", 0, -1); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("删除后 Get 错误 = %v，期望 ErrNotExist", err)
+	}
+}
+
+func TestS3Compose(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), s3MinPartSize+1)
+
+	tests := []struct {
+		name    string
+		srcs    map[string][]byte // 写入的源对象
+		huge    map[string]int64  // 只记录大小的大对象
+		order   []string
+		want    []byte // 期望的拼接结果，为 nil 时只比较大小
+		size    int64
+		wantErr error
+	}{
+		{
+			name:  "单个小对象",
+			srcs:  map[string][]byte{"a": []byte("hello")},
+			order: []string{"a"},
+			want:  []byte("hello"),
+		},
+		{
+			name:  "单个超过 5 GiB 的对象",
+			huge:  map[string]int64{"a": 6 << 30},
+			order: []string{"a"},
+			size:  6 << 30,
+		},
+		{
+			name:  "多个小对象流式拼接",
+			srcs:  map[string][]byte{"a": []byte("hello "), "b": []byte("world")},
+			order: []string{"a", "b"},
+			want:  []byte("hello world"),
+		},
+		{
+			name:  "满足服务端拼接条件",
+			srcs:  map[string][]byte{"a": big, "b": []byte("tail")},
+			order: []string{"a", "b"},
+			want:  append(append([]byte(nil), big...), "tail"...),
+		},
+		{
+			name:    "源对象不存在",
+			order:   []string{"missing"},
+			wantErr: ErrNotExist,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake := newTestS3(t)
+			ctx := context.Background()
+
+			for key, data := range tt.srcs {
+				if err := s.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+					t.Fatalf("Put %s: %v", key, err)
+				}
+			}
+			for key, size := range tt.huge {
+				fake.objects["relay/"+s.object(key)] = &fakeObject{size: size, modTime: time.Now()}
+			}
+
+			err := s.Compose(ctx, "dst", tt.order)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Compose 错误 = %v，期望 %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Compose: %v", err)
+			}
+
+			info, err := s.Stat(ctx, "dst")
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if tt.want == nil {
+				if info.Size != tt.size {
+					t.Fatalf("拼接结果大小 = %d，期望 %d", info.Size, tt.size)
+				}
+				if fake.partCalls == 0 {
+					t.Fatal("超过 5 GiB 的对象未使用分片复制")
+				}
+				return
+			}
+
+			r, err := s.Get(ctx, "dst", 0, -1)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			got, _ := io.ReadAll(r)
+			r.Close()
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("拼接结果长度 %d，期望 %d，内容不一致", len(got), len(tt.want))
+			}
+			for _, key := range tt.order {
+				if _, err := s.Stat(ctx, key); err != nil {
+					t.Fatalf("源对象 %s 被修改: %v", key, err)
+				}
+			}
+		})
+	}
+}
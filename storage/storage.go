@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"com.example/relay/config"
+)
+
+// ErrNotExist 对象不存在
+var ErrNotExist = errors.New("对象不存在")
+
+// ErrInvalidKey 对象键不合法，如包含 .. 或指向存储根目录之外
+var ErrInvalidKey = errors.New("非法的对象键")
+
+// ObjectInfo 对象元信息
+type ObjectInfo struct {
+	Key     string    // 对象键，使用 / 分隔
+	Size    int64     // 对象大小
+	ModTime time.Time // 最近修改时间
+}
+
+// Storage 存储后端接口
+// 对象键使用 / 分隔的相对路径，与具体后端无关；
+// 分块上传的暂存数据需要随机写入，仍保存在本地临时目录中，合并后才写入存储后端
+type Storage interface {
+	// Put 写入对象，size 未知时传 -1；写入是原子的，失败时不会留下不完整的对象
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// Get 读取对象从 offset 开始的 length 个字节，length < 0 表示读到末尾
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+
+	// Stat 获取对象元信息，对象不存在时返回 ErrNotExist
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+
+	// List 列出键以 prefix 开头的所有对象
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+
+	// Compose 按顺序将多个对象拼接为新对象 dst，源对象保持不变
+	Compose(ctx context.Context, dst string, srcs []string) error
+}
+
+// FileImporter 可以直接引用本地文件的存储后端，避免再复制一遍数据
+type FileImporter interface {
+	// Import 将本地文件 path 导入为对象 key，源文件保持不变，之后不能再修改源文件
+	Import(ctx context.Context, key, path string) error
+}
+
+// TempSweeper 写入时使用本地临时文件的存储后端，临时文件只会因进程中断而残留，List 不会列出它们
+type TempSweeper interface {
+	// SweepTemp 删除 prefix 目录下修改时间早于 before 的临时文件，返回被删除的文件
+	SweepTemp(ctx context.Context, prefix string, before time.Time) ([]ObjectInfo, error)
+}
+
+// New 根据配置创建存储后端
+func New(cfg *config.StorageConfig) (Storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(cfg.UploadsDir)
+	case "s3":
+		return NewS3(&cfg.S3)
+	default:
+		return nil, fmt.Errorf("不支持的存储后端: %s", cfg.Backend)
+	}
+}
+
+// readSeeker 基于范围读取实现的 io.ReadSeekCloser，Seek 之后的首次 Read 才发起读取
+type readSeeker struct {
+	ctx     context.Context
+	storage Storage
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+// NewReadSeeker 将存储中的对象包装为 io.ReadSeekCloser，便于交给 http.ServeContent 处理范围请求
+func NewReadSeeker(ctx context.Context, s Storage, key string, size int64) io.ReadSeekCloser {
+	return &readSeeker{ctx: ctx, storage: s, key: key, size: size}
+}
+
+func (r *readSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.storage.Get(r.ctx, r.key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("无效的 whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("偏移量不能为负数")
+	}
+
+	if abs != r.offset {
+		r.Close()
+		r.offset = abs
+	}
+	return abs, nil
+}
+
+func (r *readSeeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
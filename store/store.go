@@ -0,0 +1,182 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// db 嵌入式数据库实例，用于持久化需要跨重启保留的数据
+var db *bolt.DB
+
+// Init 打开（或创建）嵌入式数据库
+func Init(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建数据库目录失败: %w", err)
+	}
+
+	d, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+
+	db = d
+	return nil
+}
+
+// Close 关闭数据库
+func Close() error {
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
+// Put 以JSON格式写入一条记录
+func Put(bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// Get 读取一条记录并解析到 v 中，记录不存在时返回 false
+func Get(bucket, key string, v interface{}) (bool, error) {
+	var data []byte
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if raw := b.Get([]byte(key)); raw != nil {
+			// bolt 返回的切片仅在事务内有效，需要复制一份
+			data = append([]byte(nil), raw...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(data, v)
+}
+
+// Delete 删除一条记录
+func Delete(bucket, key string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// ForEach 按键顺序遍历桶中的所有记录
+func ForEach(bucket string, fn func(key string, data []byte) error) error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+// ForEachPrefix 按键顺序遍历桶中键以 prefix 开头的记录
+func ForEachPrefix(bucket, prefix string, fn func(key string, data []byte) error) error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if err := fn(string(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ForEachRange 按键顺序遍历桶中键不小于 start 且小于 end 的记录
+func ForEachRange(bucket, start, end string, fn func(key string, data []byte) error) error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		e := []byte(end)
+		for k, v := c.Seek([]byte(start)); k != nil && bytes.Compare(k, e) < 0; k, v = c.Next() {
+			if err := fn(string(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Tx 读写事务，用于原子地写入多个桶
+type Tx struct {
+	tx *bolt.Tx
+}
+
+// Update 在同一个读写事务中执行 fn，fn 返回错误时事务内的写入全部回滚
+func Update(fn func(tx *Tx) error) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return fn(&Tx{tx: tx})
+	})
+}
+
+// Put 以JSON格式写入一条记录
+func (t *Tx) Put(bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b, err := t.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
+
+// Get 读取一条记录并解析到 v 中，记录不存在时返回 false
+func (t *Tx) Get(bucket, key string, v interface{}) (bool, error) {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return false, nil
+	}
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Delete 删除一条记录
+func (t *Tx) Delete(bucket, key string) error {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(key))
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package utils
+
+import "errors"
+
+// DiskFree 非 Unix 平台不支持查询剩余空间，调用方应跳过检查
+func DiskFree(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
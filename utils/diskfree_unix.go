@@ -0,0 +1,14 @@
+//go:build unix
+
+package utils
+
+import "golang.org/x/sys/unix"
+
+// DiskFree 返回 path 所在文件系统中非特权用户可用的剩余空间（字节）
+func DiskFree(path string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"io"
-	"os"
 	"strconv"
 	"time"
 )
@@ -18,62 +17,48 @@ func GenerateFileID(fileName string, fileSize int64) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// GenerateUploadID 根据文件内容哈希、文件名、大小和所有者生成确定性的上传标识
+// 同一客户端重复初始化同一文件时得到相同的标识，从而可以续传
+func GenerateUploadID(fileHash, fileName string, fileSize int64, owner string) string {
+	h := md5.New()
+	io.WriteString(h, fileHash)
+	io.WriteString(h, "\x00"+fileName)
+	io.WriteString(h, "\x00"+strconv.FormatInt(fileSize, 10))
+	io.WriteString(h, "\x00"+owner)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // CalculateFileMD5 计算文件的MD5哈希值 (与SparkMD5兼容)
 func CalculateFileMD5(filePath string) (string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	hash := md5.New()
-	buf := make([]byte, 4*1024*1024) // 4MB缓冲区，可按需调整
-
-	for {
-		n, err := file.Read(buf)
-		if n > 0 {
-			hash.Write(buf[:n])
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return "", err
-		}
-	}
+	return CalculateFileHash(filePath, HashMD5)
+}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+// CalculateReaderMD5 计算数据流的MD5哈希值
+func CalculateReaderMD5(r io.Reader) (string, error) {
+	return CalculateReaderHash(r, HashMD5)
 }
 
 // CalculateDataMD5 计算数据的MD5哈希值
 func CalculateDataMD5(data []byte) string {
-	hash := md5.New()
-	hash.Write(data)
-	return hex.EncodeToString(hash.Sum(nil))
+	return CalculateDataHash(data, HashMD5)
 }
 
-// CalculateChunkMD5 计算文件块的MD5哈希值
+// CalculateChunkMD5 计算文件块的MD5哈希值，流式读取，不会将整个分块载入内存
 func CalculateChunkMD5(filePath string) (string, error) {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", err
-	}
-	return CalculateDataMD5(data), nil
+	return CalculateFileHash(filePath, HashMD5)
 }
 
-// 以下为向后兼容的函数，只是调用相应的MD5函数，用于平滑过渡
-
-// CalculateFileSHA256 已替换为MD5实现，保持API兼容
+// CalculateFileSHA256 计算文件的SHA-256哈希值
 func CalculateFileSHA256(filePath string) (string, error) {
-	return CalculateFileMD5(filePath)
+	return CalculateFileHash(filePath, HashSHA256)
 }
 
-// CalculateDataSHA256 已替换为MD5实现，保持API兼容
+// CalculateDataSHA256 计算数据的SHA-256哈希值
 func CalculateDataSHA256(data []byte) string {
-	return CalculateDataMD5(data)
+	return CalculateDataHash(data, HashSHA256)
 }
 
-// CalculateChunkSHA256 已替换为MD5实现，保持API兼容
+// CalculateChunkSHA256 计算文件块的SHA-256哈希值
 func CalculateChunkSHA256(filePath string) (string, error) {
-	return CalculateChunkMD5(filePath)
+	return CalculateFileHash(filePath, HashSHA256)
 }
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 支持的哈希算法名称
+const (
+	HashMD5    = "md5"    // 与浏览器端 SparkMD5 兼容，默认算法
+	HashSHA1   = "sha1"   // SHA-1
+	HashSHA256 = "sha256" // SHA-256
+	HashCRC32C = "crc32c" // CRC-32C（Castagnoli），只用于检测传输错误
+)
+
+// DefaultHashAlgo 客户端未指定时使用的哈希算法
+const DefaultHashAlgo = HashMD5
+
+// crc32cTable CRC-32C 多项式表
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// hashAlgorithms 哈希算法注册表
+var hashAlgorithms = map[string]func() hash.Hash{
+	HashMD5:    md5.New,
+	HashSHA1:   sha1.New,
+	HashSHA256: sha256.New,
+	HashCRC32C: func() hash.Hash { return crc32.New(crc32cTable) },
+}
+
+// hashAliases 算法名称的常见写法
+var hashAliases = map[string]string{
+	"sha-1":   HashSHA1,
+	"sha-256": HashSHA256,
+	"crc-32c": HashCRC32C,
+}
+
+// HashAlgorithms 返回所有支持的哈希算法名称
+func HashAlgorithms() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseHashAlgo 解析客户端提供的算法名称，不区分大小写，为空时返回默认算法
+func ParseHashAlgo(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return DefaultHashAlgo, nil
+	}
+	if alias, ok := hashAliases[name]; ok {
+		name = alias
+	}
+	if _, ok := hashAlgorithms[name]; !ok {
+		return "", fmt.Errorf("不支持的哈希算法 %s，可选值: %s", name, strings.Join(HashAlgorithms(), ", "))
+	}
+	return name, nil
+}
+
+// NewHash 创建指定算法的哈希计算器，算法名称需已通过 ParseHashAlgo 校验
+func NewHash(algo string) hash.Hash {
+	newHash, ok := hashAlgorithms[algo]
+	if !ok {
+		panic("未注册的哈希算法: " + algo)
+	}
+	return newHash()
+}
+
+// CalculateReaderHash 使用指定算法计算数据流的哈希值
+func CalculateReaderHash(r io.Reader, algo string) (string, error) {
+	hasher := NewHash(algo)
+	buf := make([]byte, 4*1024*1024)
+	if _, err := io.CopyBuffer(hasher, r, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// CalculateFileHash 使用指定算法计算文件的哈希值
+func CalculateFileHash(filePath, algo string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return CalculateReaderHash(file, algo)
+}
+
+// CalculateDataHash 使用指定算法计算数据的哈希值
+func CalculateDataHash(data []byte, algo string) string {
+	hasher := NewHash(algo)
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// GenerateNodeID 生成随机的节点唯一标识
+func GenerateNodeID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// GenerateSecret 生成随机的共享密钥
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// SignNodeToken 计算节点签名：HMAC-SHA256(secret, uid + ":" + timestamp)
+func SignNodeToken(secret, uid, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, uid+":"+timestamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateMessageID 生成随机的消息唯一标识
+func GenerateMessageID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Merkle 树节点的域分隔前缀，防止叶子节点与内部节点混淆（与 RFC 6962 相同）
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// ErrInvalidMerkleProof Merkle 证明与根哈希不一致
+var ErrInvalidMerkleProof = errors.New("Merkle 证明校验失败")
+
+// MerkleTree 以分块哈希值为叶子构建的 Merkle 树
+// 叶子节点为 H(0x00 || 分块哈希)，内部节点为 H(0x01 || 左 || 右)，
+// 某一层节点数为奇数时最后一个节点直接提升到上一层，H 为分块哈希使用的算法
+type MerkleTree struct {
+	algo   string
+	levels [][][]byte // levels[0] 为叶子节点，最后一层只有根节点
+}
+
+// NewMerkleTree 按顺序以十六进制分块哈希值构建 Merkle 树
+func NewMerkleTree(algo string, chunkHashes []string) (*MerkleTree, error) {
+	if len(chunkHashes) == 0 {
+		return nil, errors.New("没有分块哈希值")
+	}
+
+	leaves := make([][]byte, len(chunkHashes))
+	for i, chunkHash := range chunkHashes {
+		leaf, err := merkleLeaf(algo, chunkHash)
+		if err != nil {
+			return nil, fmt.Errorf("分块 %d 的哈希值格式不正确: %w", i, err)
+		}
+		leaves[i] = leaf
+	}
+
+	tree := &MerkleTree{algo: algo, levels: [][][]byte{leaves}}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleNode(algo, level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	return tree, nil
+}
+
+// Root 返回十六进制的根哈希
+func (t *MerkleTree) Root() string {
+	return hex.EncodeToString(t.levels[len(t.levels)-1][0])
+}
+
+// Leaves 返回叶子节点数，即分块数
+func (t *MerkleTree) Leaves() int {
+	return len(t.levels[0])
+}
+
+// Proof 返回第 index 个分块的证明：自底向上依次排列的兄弟节点的十六进制哈希值
+// 被直接提升的层没有兄弟节点，不出现在证明中
+func (t *MerkleTree) Proof(index int) ([]string, error) {
+	if index < 0 || index >= t.Leaves() {
+		return nil, fmt.Errorf("分块索引 %d 超出范围", index)
+	}
+
+	proof := []string{}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, hex.EncodeToString(level[sibling]))
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof 校验第 index 个分块（共 total 个）的哈希值能否通过证明得到根哈希
+func VerifyMerkleProof(algo, chunkHash string, index, total int, proof []string, root string) error {
+	if index < 0 || index >= total {
+		return fmt.Errorf("分块索引 %d 超出范围", index)
+	}
+
+	node, err := merkleLeaf(algo, chunkHash)
+	if err != nil {
+		return err
+	}
+	for n := total; n > 1; n = (n + 1) / 2 {
+		if index^1 < n {
+			if len(proof) == 0 {
+				return ErrInvalidMerkleProof
+			}
+			sibling, err := hex.DecodeString(proof[0])
+			if err != nil {
+				return ErrInvalidMerkleProof
+			}
+			proof = proof[1:]
+			if index%2 == 0 {
+				node = merkleNode(algo, node, sibling)
+			} else {
+				node = merkleNode(algo, sibling, node)
+			}
+		}
+		index /= 2
+	}
+
+	if len(proof) != 0 || hex.EncodeToString(node) != root {
+		return ErrInvalidMerkleProof
+	}
+	return nil
+}
+
+// merkleLeaf 计算分块哈希值对应的叶子节点
+func merkleLeaf(algo, chunkHash string) ([]byte, error) {
+	digest, err := hex.DecodeString(chunkHash)
+	if err != nil {
+		return nil, err
+	}
+	h := NewHash(algo)
+	h.Write([]byte{merkleLeafPrefix})
+	h.Write(digest)
+	return h.Sum(nil), nil
+}
+
+// merkleNode 计算内部节点
+func merkleNode(algo string, left, right []byte) []byte {
+	h := NewHash(algo)
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
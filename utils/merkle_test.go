@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testChunkHashes 生成 n 个不同的十六进制 SHA-256 分块哈希值
+func testChunkHashes(n int) []string {
+	hashes := make([]string, n)
+	for i := range hashes {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("chunk-%d", i)))
+		hashes[i] = hex.EncodeToString(sum[:])
+	}
+	return hashes
+}
+
+func TestMerkleRoot(t *testing.T) {
+	hashes := testChunkHashes(3)
+	leaf := func(i int) []byte {
+		digest, _ := hex.DecodeString(hashes[i])
+		sum := sha256.Sum256(append([]byte{0x00}, digest...))
+		return sum[:]
+	}
+	node := func(left, right []byte) []byte {
+		sum := sha256.Sum256(append(append([]byte{0x01}, left...), right...))
+		return sum[:]
+	}
+
+	tests := []struct {
+		name   string
+		hashes []string
+		want   []byte
+	}{
+		{"单个分块", hashes[:1], leaf(0)},
+		{"两个分块", hashes[:2], node(leaf(0), leaf(1))},
+		{"奇数个分块时最后一个直接提升", hashes, node(node(leaf(0), leaf(1)), leaf(2))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := NewMerkleTree(HashSHA256, tt.hashes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := tree.Root(), hex.EncodeToString(tt.want); got != want {
+				t.Fatalf("Root() = %s，期望 %s", got, want)
+			}
+		})
+	}
+
+	if _, err := NewMerkleTree(HashSHA256, nil); err == nil {
+		t.Fatal("没有分块时应当返回错误")
+	}
+	if _, err := NewMerkleTree(HashSHA256, []string{"xyz"}); err == nil {
+		t.Fatal("分块哈希值格式不正确时应当返回错误")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	// 各种分块数下每个分块的证明都能校验通过
+	for total := 1; total <= 9; total++ {
+		hashes := testChunkHashes(total)
+		tree, err := NewMerkleTree(HashSHA256, hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range hashes {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyMerkleProof(HashSHA256, hashes[i], i, total, proof, tree.Root()); err != nil {
+				t.Fatalf("%d 个分块中的第 %d 个: %v", total, i, err)
+			}
+		}
+		if _, err := tree.Proof(total); err == nil {
+			t.Fatalf("%d 个分块时索引 %d 应当超出范围", total, total)
+		}
+	}
+
+	hashes := testChunkHashes(5)
+	tree, err := NewMerkleTree(HashSHA256, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.Root()
+	proof, err := tree.Proof(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]string{hashes[0]}, proof[1:]...)
+
+	tests := []struct {
+		name      string
+		chunkHash string
+		index     int
+		total     int
+		proof     []string
+		invalid   bool // 期望返回 ErrInvalidMerkleProof，否则只要求返回错误
+	}{
+		{"分块哈希值不符", hashes[3], 2, 5, proof, true},
+		{"索引不符", hashes[2], 3, 5, proof, true},
+		{"分块数不符", hashes[2], 2, 4, proof, true},
+		{"兄弟节点被篡改", hashes[2], 2, 5, tampered, true},
+		{"证明缺少节点", hashes[2], 2, 5, proof[:len(proof)-1], true},
+		{"证明多出节点", hashes[2], 2, 5, append(append([]string{}, proof...), hashes[0]), true},
+		{"证明不是十六进制", hashes[2], 2, 5, append([]string{"xyz"}, proof[1:]...), true},
+		{"索引超出范围", hashes[2], 5, 5, proof, false},
+		{"负数索引", hashes[2], -1, 5, proof, false},
+		{"分块哈希值格式不正确", "xyz", 2, 5, proof, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyMerkleProof(HashSHA256, tt.chunkHash, tt.index, tt.total, tt.proof, root)
+			if err == nil {
+				t.Fatal("校验应当失败")
+			}
+			if tt.invalid && !errors.Is(err, ErrInvalidMerkleProof) {
+				t.Fatalf("err = %v，期望 %v", err, ErrInvalidMerkleProof)
+			}
+		})
+	}
+}
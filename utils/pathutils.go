@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidPath 客户端提供的文件路径不合法
+var ErrInvalidPath = errors.New("非法的文件路径")
+
+// CleanFilePath 规范化客户端提供的文件路径，返回以 / 分隔的相对路径
+// 反斜杠按路径分隔符处理，忽略空路径段和 "."；绝对路径、".."、控制字符、以 .tmp- 开头的路径段（存储写入中的临时文件）
+// 以及 Windows 设备名（CON、NUL、COM1 等，带扩展名时同样无法创建文件）一律拒绝
+func CleanFilePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+
+	if strings.HasPrefix(name, "/") || hasVolumeName(name) {
+		return "", fmt.Errorf("%w: 不允许使用绝对路径", ErrInvalidPath)
+	}
+
+	parts := make([]string, 0, strings.Count(name, "/")+1)
+	for _, part := range strings.Split(name, "/") {
+		switch {
+		case part == "" || part == ".":
+			continue
+		case part == "..":
+			return "", fmt.Errorf("%w: 不允许包含 ..", ErrInvalidPath)
+		case strings.HasPrefix(part, ".tmp-"):
+			return "", fmt.Errorf("%w: %s 为保留名称", ErrInvalidPath, part)
+		case strings.IndexFunc(part, isControlRune) >= 0:
+			return "", fmt.Errorf("%w: 不允许包含控制字符", ErrInvalidPath)
+		case isDeviceName(part):
+			return "", fmt.Errorf("%w: %s 为 Windows 设备名", ErrInvalidPath, part)
+		}
+		parts = append(parts, part)
+	}
+
+	if len(parts) == 0 {
+		return "", fmt.Errorf("%w: 文件名为空", ErrInvalidPath)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// hasVolumeName 是否以 Windows 盘符开头，如 C:
+func hasVolumeName(name string) bool {
+	if len(name) < 2 || name[1] != ':' {
+		return false
+	}
+	c := name[0] | 0x20
+	return c >= 'a' && c <= 'z'
+}
+
+// windowsDevices Windows 保留的设备名，不区分大小写
+var windowsDevices = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// isDeviceName 路径段是否为 Windows 设备名，Windows 忽略扩展名和结尾的空格，如 "nul.txt"、"CON " 同样指向设备
+func isDeviceName(part string) bool {
+	base, _, _ := strings.Cut(part, ".")
+	return windowsDevices[strings.ToLower(strings.TrimRight(base, " "))]
+}
+
+// isControlRune 是否为控制字符
+func isControlRune(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
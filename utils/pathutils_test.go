@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCleanFilePath(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // 为空表示应当拒绝
+	}{
+		{"普通文件名", "a.txt", "a.txt"},
+		{"多级目录", "dir/sub/a.txt", "dir/sub/a.txt"},
+		{"忽略空路径段和点", "./dir//./a.txt", "dir/a.txt"},
+		{"结尾的斜杠", "dir/a.txt/", "dir/a.txt"},
+		{"反斜杠按分隔符处理", `dir\sub\a.txt`, "dir/sub/a.txt"},
+		{"文件名中包含两个点", "a..b.txt", "a..b.txt"},
+		{"以点开头的文件名", ".hidden", ".hidden"},
+		{"包含设备名的文件名", "console.txt", "console.txt"},
+		{"设备名后跟数字", "com10", "com10"},
+
+		{"空路径", "", ""},
+		{"只有点和斜杠", "././/", ""},
+		{"上级目录", "../a.txt", ""},
+		{"中间的上级目录", "dir/../../a.txt", ""},
+		{"目录内回退也拒绝", "dir/../a.txt", ""},
+		{"反斜杠上级目录", `..\a.txt`, ""},
+		{"反斜杠混合上级目录", `dir\..\..\etc\passwd`, ""},
+		{"绝对路径", "/etc/passwd", ""},
+		{"反斜杠绝对路径", `\etc\passwd`, ""},
+		{"UNC 路径", `\\server\share\a.txt`, ""},
+		{"盘符路径", `C:\Windows\a.txt`, ""},
+		{"盘符相对路径", "c:a.txt", ""},
+		{"小写盘符正斜杠", "d:/a.txt", ""},
+		{"存储临时文件", "dir/.tmp-123", ""},
+		{"控制字符", "a\x00.txt", ""},
+		{"换行符", "a\n.txt", ""},
+		{"DEL 字符", "a\x7f.txt", ""},
+		{"设备名", "CON", ""},
+		{"小写设备名", "nul", ""},
+		{"带扩展名的设备名", "aux.txt", ""},
+		{"带空格的设备名", "PRN .log", ""},
+		{"串口设备名", "dir/COM1", ""},
+		{"并口设备名", "lpt9.tar.gz", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CleanFilePath(tt.in)
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidPath) {
+					t.Fatalf("CleanFilePath(%q) = %q, %v，期望 ErrInvalidPath", tt.in, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("CleanFilePath(%q) = %q, %v，期望 %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
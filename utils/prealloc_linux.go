@@ -0,0 +1,23 @@
+//go:build linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// PreallocateFile 为文件预先分配 size 字节的磁盘空间，避免随机写入产生碎片以及写到一半时磁盘已满
+// 文件系统不支持 fallocate 时退化为设置文件大小（稀疏文件）
+func PreallocateFile(f *os.File, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	err := unix.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return f.Truncate(size)
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package utils
+
+import "os"
+
+// PreallocateFile 设置文件大小，非 Linux 平台不支持 fallocate，得到的是稀疏文件
+func PreallocateFile(f *os.File, size int64) error {
+	return f.Truncate(size)
+}